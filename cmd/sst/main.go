@@ -654,10 +654,11 @@ var root = &cli.Command{
 				if err != nil {
 					return util.NewReadableError(err, "Could not find provider "+pkg)
 				}
-				err = p.Add(entry.Name, entry.Version)
-				if err != nil {
+				err = p.Add(entry)
+				if err != nil && err != project.ErrAlreadyPresent {
 					return err
 				}
+				added := err == nil
 				spin.Suffix = "  Downloading provider..."
 				p, err = project.New(&project.ProjectConfig{
 					Version: version,
@@ -672,6 +673,10 @@ var root = &cli.Command{
 					return err
 				}
 				spin.Stop()
+				if !added {
+					ui.Success(fmt.Sprintf("Provider \"%s\" is already in your config", entry.Alias))
+					return nil
+				}
 				ui.Success(fmt.Sprintf("Added provider \"%s\". You can create resources with `new %s.SomeResource()`", entry.Alias, entry.Alias))
 				return nil
 			},
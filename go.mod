@@ -38,6 +38,7 @@ require (
 	github.com/twitchtv/twirp v8.1.3+incompatible
 	github.com/yalp/jsonpath v0.0.0-20180802001716-5cc68e5049a0
 	golang.org/x/exp v0.0.0-20240325151524-a685a6edb6d8
+	golang.org/x/sys v0.22.0
 	google.golang.org/protobuf v1.33.0
 )
 
@@ -142,7 +143,6 @@ require (
 	golang.org/x/mod v0.19.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0
-	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/term v0.22.0
 	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
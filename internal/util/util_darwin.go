@@ -1,6 +1,8 @@
 package util
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
 	"syscall"
 )
@@ -13,6 +15,40 @@ func TerminateProcess(pid int) error {
 	return syscall.Kill(-pid, syscall.SIGTERM)
 }
 
+// KillProcess force kills the process group, for children that outlive or
+// ignore TerminateProcess. A group that is already gone is not an error.
+func KillProcess(pid int) error {
+	err := syscall.Kill(-pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return nil
+	}
+	return err
+}
+
+// ProcessGroupAlive reports whether any process is left in the group. The
+// group id is not reused while it has members, so a live group is still the
+// one the worker started.
+func ProcessGroupAlive(pid int) bool {
+	err := syscall.Kill(-pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// AttachProcessGroup is a no-op, SetProcessGroupID already put the process
+// in its own group.
+func AttachProcessGroup(cmd *exec.Cmd) error {
+	return nil
+}
+
+// SignalProcess sends the signal to the whole process group like
+// TerminateProcess.
+func SignalProcess(pid int, signal os.Signal) error {
+	sig, ok := signal.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", signal)
+	}
+	return syscall.Kill(-pid, sig)
+}
+
 // https://github.com/go-cmd/cmd/blob/master/cmd_darwin.go
 func SetProcessGroupID(cmd *exec.Cmd) {
 	// Set process group ID so the cmd and all its children become a new
@@ -3,9 +3,18 @@ package util
 import (
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
 )
 
+// jobs holds the job object of each process passed to AttachProcessGroup by
+// pid. The handle keeps referring to the same processes after the pid is
+// reused.
+var jobs sync.Map
+
 // https://github.com/go-cmd/cmd/blob/master/cmd_windows.go
 func TerminateProcess(pid int) error {
 	p, err := os.FindProcess(pid)
@@ -15,6 +24,67 @@ func TerminateProcess(pid int) error {
 	return p.Kill()
 }
 
+// AttachProcessGroup puts the started process in a job object so that
+// KillProcess can end it together with every process it starts from then on,
+// Windows has no process groups.
+func AttachProcessGroup(cmd *exec.Cmd) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	_, err = windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	if previous, ok := jobs.Swap(cmd.Process.Pid, job); ok {
+		windows.CloseHandle(previous.(windows.Handle))
+	}
+	return nil
+}
+
+// ProcessGroupAlive reports whether the process still has a job to kill.
+func ProcessGroupAlive(pid int) bool {
+	_, ok := jobs.Load(pid)
+	return ok
+}
+
+// KillProcess force kills every process in the job AttachProcessGroup put the
+// process in. A process without a job is not an error.
+func KillProcess(pid int) error {
+	job, ok := jobs.LoadAndDelete(pid)
+	if !ok {
+		return nil
+	}
+	defer windows.CloseHandle(job.(windows.Handle))
+	return windows.TerminateJobObject(job.(windows.Handle), 1)
+}
+
+// SignalProcess sends the signal to the process, Windows only supports
+// os.Kill.
+func SignalProcess(pid int, signal os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(signal)
+}
+
 // https://github.com/go-cmd/cmd/blob/master/cmd_windows.go
 func SetProcessGroupID(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
@@ -11,3 +11,5 @@ var SST_PRINT_LOGS = os.Getenv("SST_PRINT_LOGS") != ""
 var SST_BUILD_CONCURRENCY = os.Getenv("SST_BUILD_CONCURRENCY")
 var SST_SKIP_DEPENDENCY_CHECK = os.Getenv("SST_SKIP_DEPENDENCY_CHECK") != ""
 var NO_BUN = os.Getenv("NO_BUN") != ""
+var SST_PROFILE_BUILDS = os.Getenv("SST_PROFILE_BUILDS") != ""
+var SST_MAX_BUILD_CONTEXTS = os.Getenv("SST_MAX_BUILD_CONTEXTS")
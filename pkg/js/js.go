@@ -33,8 +33,9 @@ type Metafile struct {
 	Inputs map[string]struct {
 		Bytes   int `json:"bytes"`
 		Imports []struct {
-			Path string `json:"path"`
-			Kind string `json:"kind"`
+			Path     string `json:"path"`
+			Kind     string `json:"kind"`
+			External bool   `json:"external"`
 		} `json:"imports"`
 	} `json:"inputs"`
 	Outputs map[string]struct {
@@ -1,20 +1,169 @@
 package project
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 
+	"github.com/sst/ion/pkg/flag"
 	"github.com/sst/ion/pkg/global"
 )
 
-func (p *Project) Add(pkg string, version string) error {
+type PackageManager struct {
+	Name    string
+	Command string
+}
+
+var lockfiles = []struct {
+	file    string
+	manager string
+}{
+	{"bun.lockb", "bun"},
+	{"pnpm-lock.yaml", "pnpm"},
+	{"yarn.lock", "yarn"},
+	{"package-lock.json", "npm"},
+}
+
+// PackageManager picks the package manager matching the lockfile in the
+// project root, falling back to the bundled bun.
+func (p *Project) PackageManager() PackageManager {
+	return detectPackageManager(p.PathRoot())
+}
+
+func detectPackageManager(root string) PackageManager {
+	for _, lockfile := range lockfiles {
+		if _, err := os.Stat(filepath.Join(root, lockfile.file)); err != nil {
+			continue
+		}
+		if lockfile.manager == "bun" {
+			break
+		}
+		return PackageManager{Name: lockfile.manager, Command: lockfile.manager}
+	}
+	if flag.NO_BUN {
+		return PackageManager{Name: "npm", Command: "npm"}
+	}
+	return PackageManager{Name: "bun", Command: global.BunPath()}
+}
+
+// Add updates sst.config.ts with the provider and adds its package to the
+// project with the package manager matching the lockfile in the project root,
+// see PackageManager. The config is always edited with the bundled bun. Env is
+// merged over the current environment. When the provider is already in the
+// config at the same version nothing is run and ErrAlreadyPresent is
+// returned, so Add is safe to call repeatedly.
+func (p *Project) Add(provider *ProviderLockEntry, env ...string) error {
+	if p.hasProvider(provider.Name, provider.Version) {
+		return ErrAlreadyPresent
+	}
+	for _, cmd := range []*exec.Cmd{
+		p.addCommand(provider.Name, provider.Version, env),
+		p.addPackageCommand(provider.Package, provider.Version, env),
+	} {
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := runAddCommand(cmd); err != nil {
+			return &AddError{Err: classifyAddFailure(output.String(), err), Output: output.String()}
+		}
+	}
+	if p.configured == nil {
+		p.configured = map[string]string{}
+	}
+	p.configured[provider.Name] = provider.Version
+	return nil
+}
+
+// runAddCommand runs the commands Add needs, tests replace it to avoid
+// touching the registry.
+var runAddCommand = (*exec.Cmd).Run
+
+// hasProvider reports whether the config lists the provider at the version,
+// a provider that pins no version matches any. The home provider is only
+// counted when it is listed explicitly.
+func (p *Project) hasProvider(name string, version string) bool {
+	configured, ok := p.configured[name]
+	return ok && (configured == "" || version == "" || configured == version)
+}
+
+var ErrAlreadyPresent = fmt.Errorf("provider already present")
+
+var ErrRegistryAuth = fmt.Errorf("registry authentication failed")
+var ErrPackageNotFound = fmt.Errorf("package not found")
+var ErrNetwork = fmt.Errorf("registry unreachable")
+
+// AddError is returned by Add when the command fails, carrying its combined
+// output. Err is one of ErrRegistryAuth, ErrPackageNotFound or ErrNetwork when
+// the output matches a known failure, otherwise the exit error.
+type AddError struct {
+	Err    error
+	Output string
+}
+
+func (e *AddError) Error() string {
+	return fmt.Sprintf("failed to add provider: %v\n%s", e.Err, strings.TrimSpace(e.Output))
+}
+
+func (e *AddError) Unwrap() error {
+	return e.Err
+}
+
+// addFailures match the error codes and HTTP status lines npm, pnpm, yarn
+// and bun print, anchored so that versions, paths or urls containing the same
+// digits or words are not misclassified.
+var addFailures = []struct {
+	err     error
+	pattern *regexp.Regexp
+}{
+	{ErrRegistryAuth, regexp.MustCompile(`(?im)\bE40[13]\b|\b40[13] (Unauthorized|Forbidden)\b|- 40[13]\s*$|^npm (ERR!|error) need auth\b`)},
+	{ErrPackageNotFound, regexp.MustCompile(`(?im)\bE404\b|\b404 Not Found\b|- 404\s*$|\bETARGET\b|\bNo version matching\b|package "[^"]+" not found`)},
+	{ErrNetwork, regexp.MustCompile(`\b(ENOTFOUND|ECONNREFUSED|ECONNRESET|ETIMEDOUT|EAI_AGAIN|ConnectionRefused)\b`)},
+}
+
+func classifyAddFailure(output string, err error) error {
+	for _, failure := range addFailures {
+		if failure.pattern.MatchString(output) {
+			return failure.err
+		}
+	}
+	return err
+}
+
+func (p *Project) addCommand(pkg string, version string, env []string) *exec.Cmd {
 	cmd := exec.Command(global.BunPath(), filepath.Join(p.PathPlatformDir(), "src/ast/add.ts"),
 		p.PathConfig(),
 		pkg,
 		version,
 	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Dir = p.PathRoot()
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// addPackageCommand adds pkg at exactly version as a dev dependency of the
+// project with its package manager. pnpm refuses to add to a workspace root
+// without being told to.
+func (p *Project) addPackageCommand(pkg string, version string, env []string) *exec.Cmd {
+	manager := p.PackageManager()
+	spec := pkg + "@" + version
+	var args []string
+	switch manager.Name {
+	case "npm":
+		args = []string{"install", "--save-dev", "--save-exact", spec}
+	case "pnpm":
+		args = []string{"add", "--save-dev", "--save-exact", spec}
+		if _, err := os.Stat(filepath.Join(p.PathRoot(), "pnpm-workspace.yaml")); err == nil {
+			args = append(args, "--workspace-root")
+		}
+	default:
+		args = []string{"add", "--dev", "--exact", spec}
+	}
+	cmd := exec.Command(manager.Command, args...)
+	cmd.Dir = p.PathRoot()
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
 }
@@ -0,0 +1,152 @@
+package project
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sst/ion/pkg/global"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	tests := []struct {
+		lockfile string
+		expected PackageManager
+	}{
+		{"pnpm-lock.yaml", PackageManager{Name: "pnpm", Command: "pnpm"}},
+		{"yarn.lock", PackageManager{Name: "yarn", Command: "yarn"}},
+		{"package-lock.json", PackageManager{Name: "npm", Command: "npm"}},
+		{"bun.lockb", PackageManager{Name: "bun", Command: global.BunPath()}},
+		{"", PackageManager{Name: "bun", Command: global.BunPath()}},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		if test.lockfile != "" {
+			require.NoError(t, os.WriteFile(filepath.Join(root, test.lockfile), []byte{}, 0644))
+		}
+		assert.Equal(t, test.expected, detectPackageManager(root), test.lockfile)
+	}
+}
+
+func TestAddCommand(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("SST_ADD_TEST", "inherited")
+	p := &Project{root: root, config: filepath.Join(root, "sst.config.ts")}
+	cmd := p.addCommand("aws", "6.0.0", []string{"SST_ADD_TEST=override", "NPM_TOKEN=secret"})
+	assert.Equal(t, root, cmd.Dir)
+	assert.Equal(t, []string{
+		global.BunPath(),
+		filepath.Join(root, ".sst/platform/src/ast/add.ts"),
+		filepath.Join(root, "sst.config.ts"),
+		"aws",
+		"6.0.0",
+	}, cmd.Args)
+	assert.Contains(t, cmd.Env, "NPM_TOKEN=secret")
+	assert.Contains(t, cmd.Environ(), "SST_ADD_TEST=override")
+	assert.NotContains(t, cmd.Environ(), "SST_ADD_TEST=inherited")
+}
+
+func TestAddPackageCommand(t *testing.T) {
+	tests := []struct {
+		files    []string
+		expected []string
+	}{
+		{[]string{"pnpm-lock.yaml"}, []string{"pnpm", "add", "--save-dev", "--save-exact", "@pulumi/aws@6.0.0"}},
+		{[]string{"pnpm-lock.yaml", "pnpm-workspace.yaml"}, []string{"pnpm", "add", "--save-dev", "--save-exact", "@pulumi/aws@6.0.0", "--workspace-root"}},
+		{[]string{"yarn.lock"}, []string{"yarn", "add", "--dev", "--exact", "@pulumi/aws@6.0.0"}},
+		{[]string{"package-lock.json"}, []string{"npm", "install", "--save-dev", "--save-exact", "@pulumi/aws@6.0.0"}},
+		{[]string{"bun.lockb"}, []string{global.BunPath(), "add", "--dev", "--exact", "@pulumi/aws@6.0.0"}},
+		{nil, []string{global.BunPath(), "add", "--dev", "--exact", "@pulumi/aws@6.0.0"}},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		for _, file := range test.files {
+			require.NoError(t, os.WriteFile(filepath.Join(root, file), []byte{}, 0644))
+		}
+		p := &Project{root: root, config: filepath.Join(root, "sst.config.ts")}
+		cmd := p.addPackageCommand("@pulumi/aws", "6.0.0", []string{"NPM_TOKEN=secret"})
+		assert.Equal(t, test.expected, cmd.Args, test.files)
+		assert.Equal(t, root, cmd.Dir)
+		assert.Contains(t, cmd.Env, "NPM_TOKEN=secret")
+	}
+}
+
+func TestClassifyAddFailure(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		output   string
+		expected error
+	}{
+		{"error: GET https://npm.pkg.github.com/@acme%2fprovider - 401\n", ErrRegistryAuth},
+		{"npm ERR! code E403\nnpm ERR! 403 Forbidden - GET https://registry.npmjs.org/@acme/provider\n", ErrRegistryAuth},
+		{"error: package \"@pulumi/awss\" not found registry.npmjs.org/@pulumi%2fawss 404\n", ErrPackageNotFound},
+		{"error: No version matching \"99.0.0\" found for specifier \"@pulumi/aws\"\n", ErrPackageNotFound},
+		{"error: ConnectionRefused downloading package manifest @pulumi/aws\n", ErrNetwork},
+		{"npm ERR! code ENOTFOUND\nnpm ERR! network request to https://registry.npmjs.org failed\n", ErrNetwork},
+		{"npm ERR! code E404\nnpm ERR! 404 Not Found - GET https://registry.npmjs.org/@acme%2fmissing\n", ErrPackageNotFound},
+		{"npm ERR! code ETIMEDOUT\n", ErrNetwork},
+		{"SyntaxError: Unexpected token\n", exit},
+		{"error: @pulumi/aws@4.0.401 failed to resolve peer dependency\n", exit},
+		{"error: could not read /home/me/network/app/sst.config.ts\n", exit},
+		{"error: Cannot find module \"./404\"\n", exit},
+		{"error: export \"provider\" not found in @acme/provider\n", exit},
+		{"warn: skipping authentication plugin\n", exit},
+		{"npm ERR! need auth This command requires you to be logged in.\n", ErrRegistryAuth},
+		{"error \"401 Unauthorized\" fetching https://npm.pkg.github.com/@acme%2fprovider\n", ErrRegistryAuth},
+		{"error: @acme/unauthorized-redirect failed to build\n", exit},
+		{"warn: Unauthorized requests are logged by the provider\n", exit},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, classifyAddFailure(test.output, exit), test.output)
+	}
+}
+
+func TestAddError(t *testing.T) {
+	err := error(&AddError{Err: ErrRegistryAuth, Output: "error: 401 Unauthorized\n"})
+	assert.ErrorIs(t, err, ErrRegistryAuth)
+	assert.NotErrorIs(t, err, ErrNetwork)
+	assert.Contains(t, err.Error(), "401 Unauthorized")
+}
+
+func TestAddAlreadyPresent(t *testing.T) {
+	root := t.TempDir()
+	config := filepath.Join(root, "sst.config.ts")
+	contents := []byte(`export default $config({ app() { return { name: "app", home: "aws", providers: { cloudflare: "5.0.0" } }; } });`)
+	require.NoError(t, os.WriteFile(config, contents, 0644))
+	runs := []string{}
+	previous := runAddCommand
+	runAddCommand = func(cmd *exec.Cmd) error {
+		runs = append(runs, strings.Join(cmd.Args[1:], " "))
+		return nil
+	}
+	t.Cleanup(func() { runAddCommand = previous })
+	p := &Project{
+		root:       root,
+		config:     config,
+		app:        &App{Home: "aws", Providers: map[string]interface{}{"aws": map[string]interface{}{}, "cloudflare": map[string]interface{}{"version": "5.0.0"}}},
+		configured: map[string]string{"cloudflare": "5.0.0"},
+	}
+	assert.ErrorIs(t, p.Add(&ProviderLockEntry{Name: "cloudflare", Package: "@pulumi/cloudflare", Version: "5.0.0"}), ErrAlreadyPresent)
+	assert.Empty(t, runs)
+
+	random := &ProviderLockEntry{Name: "random", Package: "@pulumi/random", Version: "4.16.0"}
+	require.NoError(t, p.Add(random))
+	require.Len(t, runs, 2)
+	assert.Contains(t, runs[0], "random 4.16.0")
+	assert.Contains(t, runs[1], "@pulumi/random@4.16.0")
+	assert.ErrorIs(t, p.Add(random), ErrAlreadyPresent)
+	assert.Len(t, runs, 2)
+
+	require.NoError(t, p.Add(&ProviderLockEntry{Name: "random", Package: "@pulumi/random", Version: "4.17.0"}))
+	assert.Len(t, runs, 4)
+
+	data, err := os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+	assert.False(t, p.hasProvider("aws", ""), "implied home provider is not in the config")
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
@@ -19,6 +20,7 @@ import (
 	"github.com/sst/ion/pkg/js"
 	"github.com/sst/ion/pkg/project/provider"
 	"github.com/sst/ion/pkg/runtime"
+	"github.com/sst/ion/pkg/runtime/bun"
 	"github.com/sst/ion/pkg/runtime/node"
 	"github.com/sst/ion/pkg/runtime/python"
 	"github.com/sst/ion/pkg/runtime/worker"
@@ -46,7 +48,11 @@ type Project struct {
 	home            provider.Home
 	env             map[string]string
 	loadedProviders map[string]provider.Provider
-	Runtime         *runtime.Collection
+	// configured holds the providers listed in the config with the version
+	// they pin, empty when they do not. Unlike app.Providers it does not
+	// include an implied home provider.
+	configured map[string]string
+	Runtime    *runtime.Collection
 }
 
 func Discover() (string, error) {
@@ -100,6 +106,9 @@ func New(input *ProjectConfig) (*Project, error) {
 
 	rootPath := filepath.Dir(input.Config)
 
+	// Dev keeps an esbuild context per function, bound them in large apps.
+	maxContexts, _ := strconv.Atoi(flag.SST_MAX_BUILD_CONTEXTS)
+
 	proj := &Project{
 		version: input.Version,
 		root:    rootPath,
@@ -107,7 +116,8 @@ func New(input *ProjectConfig) (*Project, error) {
 		env:     map[string]string{},
 		Runtime: runtime.NewCollection(
 			input.Config,
-			node.New(),
+			node.New(node.WithMaxContexts(maxContexts)),
+			bun.New(node.WithMaxContexts(maxContexts)),
 			worker.New(),
 			python.New(),
 		),
@@ -178,12 +188,20 @@ console.log("~j" + JSON.stringify(mod.app({
 				proj.app.Providers = map[string]interface{}{}
 			}
 
+			proj.configured = map[string]string{}
 			for name, args := range proj.app.Providers {
+				proj.configured[name] = ""
+				if argsMap, ok := args.(map[string]interface{}); ok {
+					if version, ok := argsMap["version"].(string); ok {
+						proj.configured[name] = version
+					}
+				}
 				if argsBool, ok := args.(bool); ok && argsBool {
 					proj.app.Providers[name] = make(map[string]interface{})
 				}
 
 				if argsString, ok := args.(string); ok {
+					proj.configured[name] = argsString
 					proj.app.Providers[name] = map[string]interface{}{
 						"version": argsString,
 					}
@@ -0,0 +1,30 @@
+package bun
+
+import (
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/sst/ion/pkg/runtime/node"
+)
+
+// Runtime builds functions with the same esbuild pipeline as the node runtime
+// but prefers bun export conditions and runs workers with bun.
+type Runtime struct {
+	*node.Runtime
+}
+
+func New(options ...node.Option) *Runtime {
+	options = append([]node.Option{
+		node.WithCommand("bun"),
+		node.WithBuildOptions(func(options *esbuild.BuildOptions) {
+			options.Conditions = append([]string{"bun"}, options.Conditions...)
+		}),
+	}, options...)
+	return &Runtime{
+		Runtime: node.New(options...),
+	}
+}
+
+func (r *Runtime) Match(runtime string) bool {
+	return strings.HasPrefix(runtime, "bun")
+}
@@ -0,0 +1,69 @@
+package bun
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	r := New()
+	assert.True(t, r.Match("bun"))
+	assert.True(t, r.Match("bun1.x"))
+	assert.False(t, r.Match("nodejs20.x"))
+}
+
+func TestBuildAndRun(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"sst.config.ts":                              "",
+		"src/index.ts":                               `import { value } from "dep"; export const handler = () => value;`,
+		"node_modules/dep/package.json":              `{"name":"dep","exports":{"bun":"./bun.js","default":"./node.js"}}`,
+		"node_modules/dep/bun.js":                    `export const value = "from-bun";`,
+		"node_modules/dep/node.js":                   `export const value = "from-node";`,
+		".sst/platform/dist/nodejs-runtime/index.js": `console.log("started " + process.argv[2]);`,
+	}
+	for name, contents := range files {
+		file := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(contents), 0644))
+	}
+	cfgPath := filepath.Join(root, "sst.config.ts")
+
+	r := New()
+	input := &runtime.BuildInput{
+		CfgPath:    cfgPath,
+		FunctionID: "fn",
+		Handler:    "src/index.handler",
+		Runtime:    "bun",
+	}
+	out, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	data, err := os.ReadFile(filepath.Join(input.Out(), "src/index.mjs"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "from-bun")
+	assert.NotContains(t, string(data), "from-node")
+
+	if _, err := exec.LookPath("bun"); err != nil {
+		t.Skip("bun is not installed")
+	}
+	out.Out = input.Out()
+	worker, err := r.Run(context.Background(), &runtime.RunInput{
+		CfgPath:  cfgPath,
+		Runtime:  "bun",
+		WorkerID: "worker",
+		Build:    out,
+	})
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(worker.Logs())
+	require.True(t, scanner.Scan())
+	assert.Equal(t, "started "+filepath.Join(input.Out(), "src/index.handler"), scanner.Text())
+}
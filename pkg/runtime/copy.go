@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyFile copies From into a function's output at To. From is relative to
+// the root the files are copied from and may be a glob, in which case To is
+// the directory the matches are copied into. To defaults to the path of From
+// relative to the root.
+type CopyFile struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// OutputPath joins name onto out, rejecting names that are absolute or that
+// point outside of out.
+func OutputPath(out string, name string) (string, error) {
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q must be a path inside the output directory", name)
+	}
+	return filepath.Join(out, name), nil
+}
+
+// Matches returns the paths the entry copies from root. From is used as is
+// when it exists, so literal paths like a [id] route directory are not taken
+// for globs, otherwise it is matched as a glob. glob reports the latter.
+func (entry CopyFile) Matches(root string) (matches []string, glob bool, err error) {
+	pattern := entry.From
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(root, pattern)
+	}
+	if _, err := os.Stat(pattern); err == nil {
+		return []string{pattern}, false, nil
+	}
+	matches, err = filepath.Glob(pattern)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid copy pattern %q: %w", entry.From, err)
+	}
+	if len(matches) == 0 {
+		return nil, false, fmt.Errorf("copy source not found: %v", pattern)
+	}
+	return matches, strings.ContainsAny(entry.From, "*?["), nil
+}
+
+// CopyFiles copies the entries from root into out, directories are copied
+// recursively with symlinks followed. With link set the sources are
+// symlinked instead so changes show up without a rebuild.
+func CopyFiles(root string, out string, entries []CopyFile, link bool) error {
+	for _, entry := range entries {
+		matches, glob, err := entry.Matches(root)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			name := entry.To
+			if name == "" {
+				name, err = filepath.Rel(root, match)
+				if err != nil {
+					return err
+				}
+			} else if glob {
+				name = filepath.Join(name, filepath.Base(match))
+			}
+			dest, err := OutputPath(out, name)
+			if err != nil {
+				return fmt.Errorf("invalid copy destination for %v: %w", entry.From, err)
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if link {
+				err = os.Symlink(match, dest)
+			} else {
+				err = CopyDir(match, dest)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy %v: %w", match, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CopyDir recursively copies src to dest, following symlinks. src may also
+// be a single file.
+func CopyDir(src string, dest string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return CopyDir(path, target)
+			}
+		}
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src string, dest string, mode os.FileMode) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	_, err = io.Copy(destination, source)
+	return err
+}
@@ -0,0 +1,85 @@
+package node
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveTime is used as the modification time of every archive entry so
+// the same output always produces the same zip.
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveDir writes a deterministic zip of src to dest. Symlinks are
+// dereferenced so a linked node_modules is archived with its real contents.
+func archiveDir(src string, dest string) error {
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	if err := archiveWalk(writer, src, "", map[string]bool{}); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func archiveWalk(writer *zip.Writer, src string, prefix string, visiting map[string]bool) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	// Skip links back into a directory that is already being archived.
+	if visiting[src] {
+		return nil
+	}
+	visiting[src] = true
+	defer delete(visiting, src)
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return archiveWalk(writer, path, name, visiting)
+			}
+		}
+		if info.IsDir() {
+			return nil
+		}
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: archiveTime,
+		}
+		header.SetMode(info.Mode().Perm())
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		_, err = io.Copy(entry, source)
+		return err
+	})
+}
@@ -2,14 +2,22 @@ package node
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	goruntime "runtime"
 	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	esbuild "github.com/evanw/esbuild/pkg/api"
 	"github.com/sst/ion/internal/fs"
@@ -22,14 +30,142 @@ var forceExternal = []string{
 	"sharp", "pg-native",
 }
 
+var missingLoaderRegex = regexp.MustCompile(`^No loader is configured for "([^"]+)" files`)
+
+var unresolvedRegex = regexp.MustCompile(`^Could not resolve "([^"]+)"`)
+
+var targetMap = map[string]esbuild.Target{
+	"es2015": esbuild.ES2015,
+	"es2016": esbuild.ES2016,
+	"es2017": esbuild.ES2017,
+	"es2018": esbuild.ES2018,
+	"es2019": esbuild.ES2019,
+	"es2020": esbuild.ES2020,
+	"es2021": esbuild.ES2021,
+	"es2022": esbuild.ES2022,
+	"es2023": esbuild.ES2023,
+	"esnext": esbuild.ESNext,
+}
+
+type buildMode int
+
+const (
+	buildWrite buildMode = iota
+	// buildDry keeps the output in memory.
+	buildDry
+	// buildWarm only creates the function's build context.
+	buildWarm
+)
+
 func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtime.BuildOutput, error) {
-	var properties NodeProperties
-	json.Unmarshal(input.Properties, &properties)
+	done := r.observeBuild(input.FunctionID)
+	output, err := r.build(ctx, input, buildWrite)
+	done(output, err)
+	return output, err
+}
+
+// BuildDry builds the function in memory without writing to input.Out(),
+// returning the output files and metafile instead.
+func (r *Runtime) BuildDry(ctx context.Context, input *runtime.BuildInput) (*runtime.BuildOutput, error) {
+	done := r.observeBuild(input.FunctionID)
+	output, err := r.build(ctx, input, buildDry)
+	done(output, err)
+	return output, err
+}
 
-	file, ok := r.getFile(input)
-	if !ok {
-		return nil, fmt.Errorf("Handler not found: %v", input.Handler)
+// Warm creates the build contexts of the given functions concurrently
+// without building them, so their first Build skips the setup. Functions
+// that already have a context are left alone. A failing function does not
+// stop the others, their errors are joined.
+func (r *Runtime) Warm(ctx context.Context, inputs []*runtime.BuildInput) error {
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input *runtime.BuildInput) {
+			defer wg.Done()
+			if _, err := r.build(ctx, input, buildWarm); err != nil {
+				errs[i] = fmt.Errorf("failed to warm function %v: %w", input.FunctionID, err)
+			}
+		}(i, input)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// BuildAll builds the inputs concurrently, at most BuildConcurrency at a
+// time, returning an output per input in the same order. A function that
+// fails to build gets an output carrying the error rather than stopping the
+// others. Cancelling ctx skips builds that have not started and is returned.
+func (r *Runtime) BuildAll(ctx context.Context, inputs []*runtime.BuildInput) ([]*runtime.BuildOutput, error) {
+	outputs := make([]*runtime.BuildOutput, len(inputs))
+	slots := make(chan struct{}, max(1, r.options.BuildConcurrency))
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input *runtime.BuildInput) {
+			defer wg.Done()
+			var err error
+			select {
+			case slots <- struct{}{}:
+				if err = ctx.Err(); err == nil {
+					outputs[i], err = r.Build(ctx, input)
+				}
+				<-slots
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				outputs[i] = &runtime.BuildOutput{
+					Out:     input.Out(),
+					Handler: input.Handler,
+					Errors:  []string{err.Error()},
+				}
+			}
+		}(i, input)
 	}
+	wg.Wait()
+	return outputs, ctx.Err()
+}
+
+func (r *Runtime) build(ctx context.Context, input *runtime.BuildInput, mode buildMode) (*runtime.BuildOutput, error) {
+	dry := mode == buildDry
+	if mode == buildWrite {
+		if cached, ok := r.cachedBuild(input); ok {
+			slog.Info("function unchanged, using cached build", "functionID", input.FunctionID)
+			return cached, nil
+		}
+	}
+	scratch := ""
+	if r.options.ScratchDir != "" && mode == buildWrite {
+		dir, err := r.scratchDir(input.FunctionID)
+		if err != nil {
+			return nil, err
+		}
+		scratch = dir
+	}
+
+	properties, warnings, err := parseProperties(input.Properties)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		slog.Warn(warning, "functionID", input.FunctionID, "handler", input.Handler)
+	}
+
+	var files []string
+	if properties.VirtualEntry != nil {
+		if properties.VirtualEntry.Filename == "" {
+			return nil, fmt.Errorf("virtualEntry requires a filename")
+		}
+		files = []string{filepath.Join(path.ResolveRootDir(input.CfgPath), properties.VirtualEntry.Filename)}
+	} else {
+		files, err = r.getFiles(input, handlerExtensions(properties))
+		if err != nil {
+			return nil, err
+		}
+	}
+	file := files[0]
 
 	isESM := true
 	extension := ".mjs"
@@ -38,48 +174,154 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 		isESM = false
 		extension = ".cjs"
 	}
+	if properties.Format == "iife" {
+		isESM = false
+		extension = ".js"
+	}
+	if properties.OutExtension != "" {
+		if !strings.HasPrefix(properties.OutExtension, ".") || strings.ContainsAny(properties.OutExtension, `/\`) {
+			return nil, fmt.Errorf("invalid outExtension %q, expected an extension such as .js", properties.OutExtension)
+		}
+		extension = properties.OutExtension
+	}
 
-	rel, err := filepath.Rel(path.ResolveRootDir(input.CfgPath), file)
+	root, err := filepath.Abs(path.ResolveRootDir(input.CfgPath))
+	if err != nil {
+		return nil, err
+	}
+	entry, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, entry)
 	if err != nil {
 		return nil, err
 	}
-	target := filepath.Join(input.Out(), strings.ReplaceAll(rel, filepath.Ext(rel), extension))
+	handler := input.Handler
+	if properties.LowercaseOutput {
+		dir, base := filepath.Split(rel)
+		rel = filepath.Join(dir, strings.ToLower(base))
+		dir, base = filepath.Split(handler)
+		if index := strings.LastIndex(base, "."); index != -1 {
+			handler = dir + strings.ToLower(base[:index]) + base[index:]
+		}
+	}
+	if properties.OutputName != "" {
+		if len(files) > 1 {
+			return nil, fmt.Errorf("outputName can not be used with a handler matching multiple files")
+		}
+		name := filepath.Clean(properties.OutputName)
+		if _, err := runtime.OutputPath(input.Out(), name); err != nil {
+			return nil, fmt.Errorf("invalid outputName %q, it must be a path inside the output directory", properties.OutputName)
+		}
+		rel = name + filepath.Ext(rel)
+		handler = filepath.ToSlash(name) + "." + handlerExport(input.Handler, file)
+	}
+	target := filepath.Join(input.Out(), strings.TrimSuffix(rel, filepath.Ext(rel))+extension)
+	if mode != buildDry {
+		claims := []output{{target: target, source: file}}
+		for _, other := range files[1:] {
+			other, err := filepath.Abs(other)
+			if err != nil {
+				return nil, err
+			}
+			otherRel, err := filepath.Rel(root, other)
+			if err != nil {
+				return nil, err
+			}
+			claims = append(claims, output{
+				target: filepath.Join(input.Out(), strings.TrimSuffix(otherRel, filepath.Ext(otherRel))+extension),
+				source: other,
+			})
+		}
+		if err := r.claimOutputs(input.FunctionID, claims); err != nil {
+			return nil, err
+		}
+	}
 
-	slog.Info("loader info", "loader", properties.Loader)
+	slog.Info("loader info", "functionID", input.FunctionID, "loader", properties.Loader)
 
 	loader := map[string]esbuild.Loader{}
 	for key, value := range properties.Loader {
 		mapped, ok := loaderMap[value]
 		if !ok {
+			valid := make([]string, 0, len(loaderMap))
+			for name := range loaderMap {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			warning := fmt.Sprintf("ignoring unknown loader %q for %q, expected one of %s", value, key, strings.Join(valid, ", "))
+			slog.Warn(warning, "functionID", input.FunctionID, "handler", input.Handler)
+			warnings = append(warnings, warning)
 			continue
 		}
 		loader[key] = mapped
 	}
 
+	bundle := properties.Bundle == nil || *properties.Bundle
+	if !bundle && properties.Splitting {
+		return nil, fmt.Errorf("splitting requires bundling, remove splitting or enable bundle")
+	}
+	if !isESM && properties.Splitting {
+		return nil, fmt.Errorf("code splitting requires ESM format, remove splitting or set format to esm")
+	}
+
 	plugins := []esbuild.Plugin{}
-	external := append(forceExternal, properties.Install...)
-	external = append(external, properties.ESBuild.External...)
+	pluginScript := filepath.Join(path.ResolvePlatformDir(input.CfgPath), "functions/nodejs-runtime/plugin.mjs")
+	if properties.VirtualEntry != nil {
+		files = []string{entry}
+		plugins = append(plugins, virtualEntryPlugin(entry, properties.VirtualEntry.Contents))
+	}
+	for _, config := range properties.Plugins {
+		plugins = append(plugins, plugin(pluginScript, root, config))
+	}
+	platform := esbuild.PlatformNode
+	if properties.Platform != "" {
+		mapped, ok := platformMap[properties.Platform]
+		if !ok {
+			return nil, fmt.Errorf("unknown platform %q, expected node, browser, or neutral", properties.Platform)
+		}
+		platform = mapped
+	}
+	defaultExternal := forceExternal
+	if properties.DefaultExternals != nil {
+		defaultExternal = properties.DefaultExternals
+	}
+	if platform != esbuild.PlatformNode {
+		defaultExternal = []string{}
+	}
+	external := append([]string{}, defaultExternal...)
+	// Builtins are provided by node, keep them external even when esbuild
+	// overrides change the platform.
+	if platform == esbuild.PlatformNode {
+		external = append(external, "node:*")
+	}
+	external = append(external, properties.Install...)
 	serializedLinks, err := json.Marshal(input.Links)
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("serialized links", "links", string(serializedLinks))
+	slog.Info("serialized links", "functionID", input.FunctionID, "links", string(serializedLinks))
 	options := esbuild.BuildOptions{
-		EntryPoints: []string{file},
-		Platform:    esbuild.PlatformNode,
-		External:    external,
-		Loader:      loader,
-		KeepNames:   true,
-		Bundle:      true,
-		Splitting:   properties.Splitting,
-		Metafile:    true,
-		Outfile:     target,
-		Plugins:     plugins,
-		Sourcemap:   esbuild.SourceMapLinked,
-		Write:       true,
-		Format:      esbuild.FormatESModule,
-		Target:      esbuild.ESNext,
-		MainFields:  []string{"module", "main"},
+		EntryPoints: files,
+		// Resolve aliases and metafile paths relative to the project root
+		// rather than wherever the CLI was started from.
+		AbsWorkingDir: root,
+		Platform:      platform,
+		External:      external,
+		Loader:        loader,
+		Alias:         properties.Alias,
+		KeepNames:     true,
+		Bundle:        bundle,
+		Splitting:     properties.Splitting,
+		Metafile:      true,
+		Outfile:       target,
+		Plugins:       plugins,
+		Sourcemap:     esbuild.SourceMapLinked,
+		Write:         true,
+		Format:        esbuild.FormatESModule,
+		Target:        esbuild.ESNext,
+		MainFields:    []string{"module", "main"},
 		Banner: map[string]string{
 			"js": strings.Join([]string{
 				`import { createRequire as topLevelCreateRequire } from 'module';`,
@@ -88,7 +330,7 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 				`const __filename = topLevelFileUrlToPath(import.meta.url)`,
 				`const __dirname = topLevelFileUrlToPath(new topLevelURL(".", import.meta.url))`,
 				`globalThis.$SST_LINKS = ` + string(serializedLinks) + `;`,
-				properties.Banner,
+				properties.Banner["js"],
 			}, "\n"),
 		},
 	}
@@ -100,15 +342,61 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 		options.Banner = map[string]string{
 			"js": strings.Join([]string{
 				`globalThis.$SST_LINKS = ` + string(serializedLinks) + `;`,
-				properties.Banner,
+				properties.Banner["js"],
+			}, "\n"),
+		}
+	}
+
+	if properties.Format == "iife" {
+		options.Format = esbuild.FormatIIFE
+		options.GlobalName = properties.GlobalName
+	}
+
+	if platform != esbuild.PlatformNode {
+		options.MainFields = nil
+		options.Banner = map[string]string{
+			"js": strings.Join([]string{
+				`globalThis.$SST_LINKS = ` + string(serializedLinks) + `;`,
+				properties.Banner["js"],
 			}, "\n"),
 		}
 	}
 
+	if properties.Banner["css"] != "" {
+		options.Banner["css"] = properties.Banner["css"]
+	}
+	if len(properties.Footer) > 0 {
+		options.Footer = map[string]string{}
+		for key, value := range properties.Footer {
+			options.Footer[key] = value
+		}
+	}
+
+	if r.options.BuildOptions != nil {
+		r.options.BuildOptions(&options)
+	}
+
+	// Splitting writes chunks next to the entry so it needs an output
+	// directory, the entry keeps its usual path under Out through EntryNames.
 	if properties.Splitting {
-		options.Outdir = filepath.Dir(target)
+		options.Outdir = input.Out()
+		options.Outbase = root
+		options.EntryNames = "[dir]/[name]"
+		if properties.OutputName != "" {
+			options.EntryNames = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+			options.Outbase = ""
+		}
+		options.OutExtension = map[string]string{
+			".js": extension,
+		}
+		options.Outfile = ""
+	}
+
+	if len(files) > 1 {
+		options.Outdir = input.Out()
+		options.Outbase = root
 		options.OutExtension = map[string]string{
-			".js": ".mjs",
+			".js": extension,
 		}
 		options.Outfile = ""
 	}
@@ -124,52 +412,399 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 		}
 	}
 
-	if properties.ESBuild.Target != 0 {
-		options.Target = properties.ESBuild.Target
+	mergeESBuild(&options, properties.ESBuild)
+
+	// Keep import resolution consistent with handler resolution, esbuild
+	// falls back to its own defaults when this is empty.
+	if len(properties.ResolveExtensions) > 0 {
+		options.ResolveExtensions = properties.ResolveExtensions
+	}
+	if len(properties.MainFields) > 0 {
+		options.MainFields = properties.MainFields
+	}
+
+	if properties.JSX != "" {
+		jsx, ok := jsxMap[properties.JSX]
+		if !ok {
+			return nil, fmt.Errorf("unknown jsx mode %q, expected automatic, transform, or preserve", properties.JSX)
+		}
+		options.JSX = jsx
+	}
+	if properties.JSXFactory != "" {
+		options.JSXFactory = properties.JSXFactory
+	}
+	if properties.JSXFragment != "" {
+		options.JSXFragment = properties.JSXFragment
+	}
+	if properties.JSXImportSource != "" {
+		options.JSXImportSource = properties.JSXImportSource
+	}
+	if properties.JSXDev != nil {
+		options.JSXDev = *properties.JSXDev
+	} else if input.Dev && options.JSX == esbuild.JSXAutomatic {
+		options.JSXDev = true
+	}
+
+	if properties.TreeShaking != nil {
+		options.TreeShaking = esbuild.TreeShakingFalse
+		if *properties.TreeShaking {
+			options.TreeShaking = esbuild.TreeShakingTrue
+		}
+	}
+
+	if properties.KeepNames != nil {
+		options.KeepNames = *properties.KeepNames
+	}
+
+	if properties.AllowOverwrite != nil {
+		options.AllowOverwrite = *properties.AllowOverwrite
+	}
+
+	if properties.IgnoreAnnotations != nil {
+		options.IgnoreAnnotations = *properties.IgnoreAnnotations
+	}
+
+	for _, inject := range properties.Inject {
+		file := filepath.Join(root, inject)
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("inject file not found: %v", file)
+		}
+		options.Inject = append(options.Inject, file)
+	}
+
+	if len(properties.Supported) > 0 {
+		if options.Supported == nil {
+			options.Supported = map[string]bool{}
+		}
+		for feature, supported := range properties.Supported {
+			options.Supported[feature] = supported
+		}
+	}
+
+	for _, nodePath := range properties.NodePaths {
+		options.NodePaths = append(options.NodePaths, filepath.Join(root, nodePath))
+	}
+	options.Pure = append(options.Pure, properties.Pure...)
+	options.Conditions = append(options.Conditions, properties.Conditions...)
+
+	if properties.LegalComments != "" {
+		legalComments, ok := legalCommentsMap[properties.LegalComments]
+		if !ok {
+			return nil, fmt.Errorf("unknown legalComments %q, expected none, inline, eof, linked, or external", properties.LegalComments)
+		}
+		options.LegalComments = legalComments
+	}
+
+	if properties.LogLevel != "" {
+		logLevel, ok := logLevelMap[properties.LogLevel]
+		if !ok {
+			return nil, fmt.Errorf("unknown logLevel %q, expected silent, error, warning, or info", properties.LogLevel)
+		}
+		options.LogLevel = logLevel
+	}
+
+	if properties.Charset != "" {
+		charset, ok := charsetMap[properties.Charset]
+		if !ok {
+			return nil, fmt.Errorf("unknown charset %q, expected ascii or utf8", properties.Charset)
+		}
+		options.Charset = charset
+	}
+
+	if properties.EntryNames != "" {
+		options.EntryNames = properties.EntryNames
+	}
+	if properties.ChunkNames != "" {
+		options.ChunkNames = properties.ChunkNames
+	}
+	if properties.AssetNames != "" {
+		options.AssetNames = properties.AssetNames
+	}
+	if properties.PublicPath != "" {
+		options.PublicPath = properties.PublicPath
+	}
+
+	if properties.Tsconfig != "" {
+		tsconfig := filepath.Join(root, properties.Tsconfig)
+		if _, err := os.Stat(tsconfig); err != nil {
+			return nil, fmt.Errorf("tsconfig not found: %v", tsconfig)
+		}
+		options.Tsconfig = tsconfig
+	}
+	if properties.TsconfigRaw != "" {
+		options.TsconfigRaw = properties.TsconfigRaw
+	}
+
+	if properties.BundleDependencies != nil && !*properties.BundleDependencies {
+		options.Packages = esbuild.PackagesExternal
+	}
+
+	r.lock.Lock()
+	delete(r.defineFiles, input.FunctionID)
+	if properties.DefineFile != "" {
+		r.defineFiles[input.FunctionID] = filepath.Join(root, properties.DefineFile)
+	}
+	r.lock.Unlock()
+	if properties.DefineFile != "" || len(properties.Define) > 0 {
+		define := map[string]string{}
+		for key, value := range options.Define {
+			define[key] = value
+		}
+		if properties.DefineFile != "" {
+			values, err := loadDefineFile(filepath.Join(root, properties.DefineFile))
+			if err != nil {
+				return nil, err
+			}
+			for key, value := range values {
+				define[key] = value
+			}
+		}
+		for key, value := range properties.Define {
+			define[key] = value
+		}
+		options.Define = define
+	}
+
+	browserField := platform == esbuild.PlatformBrowser
+	if properties.BrowserField != nil {
+		browserField = *properties.BrowserField
+	}
+	if browserField && platform == esbuild.PlatformNode {
+		return nil, fmt.Errorf("browserField requires the browser or neutral platform")
+	}
+	// esbuild only reads the browser field on its browser platform, so that
+	// is used for neutral builds that want it and browser builds that skip it
+	// are built as neutral with the rest of the browser defaults.
+	if browserField {
+		options.Platform = esbuild.PlatformBrowser
+	}
+	if !browserField && platform == esbuild.PlatformBrowser {
+		options.Platform = esbuild.PlatformNeutral
+		options.Conditions = append(options.Conditions, "browser")
+		if len(properties.MainFields) == 0 {
+			options.MainFields = []string{"module", "main"}
+		}
+		if !hasDefine(options.Define, "process", "process.env", "process.env.NODE_ENV") {
+			define := map[string]string{"process.env.NODE_ENV": `"development"`}
+			if options.MinifySyntax {
+				define["process.env.NODE_ENV"] = `"production"`
+			}
+			for key, value := range options.Define {
+				define[key] = value
+			}
+			options.Define = define
+		}
+	}
+
+	if properties.Target != "" {
+		target, engines, err := parseTarget(properties.Target)
+		if err != nil {
+			return nil, err
+		}
+		options.Target = target
+		options.Engines = engines
+	}
+
+	external, malformed := normalizeExternal(options.External)
+	for _, entry := range malformed {
+		slog.Warn("ignoring malformed external, wildcards take a single trailing *", "functionID", input.FunctionID, "external", entry)
+	}
+	options.External = external
+
+	// esbuild rejects externals without bundling, nothing is resolved anyway.
+	if !bundle {
+		options.External = nil
+		options.Packages = esbuild.PackagesDefault
 	}
 
-	buildContext, ok := r.contexts[input.FunctionID]
-	if !ok {
-		buildContext, _ = esbuild.Context(options)
-		r.contexts[input.FunctionID] = buildContext
+	if mode == buildWarm {
+		return nil, r.warm(input.FunctionID, options)
 	}
 
-	result := buildContext.Rebuild()
-	r.results[input.FunctionID] = result
+	var result esbuild.BuildResult
+	incremental := false
+	var duration time.Duration
+	if dry {
+		options.Write = false
+		start := time.Now()
+		result = esbuild.Build(options)
+		duration = time.Since(start)
+	} else {
+		buildCtx := ctx
+		if properties.Timeout != "" {
+			timeout, err := time.ParseDuration(properties.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout %q: %w", properties.Timeout, err)
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				buildCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		result, incremental, duration, err = r.rebuild(buildCtx, input.FunctionID, options)
+		if err != nil {
+			return nil, fmt.Errorf("build of function %v stopped after %v: %w", input.FunctionID, duration.Round(time.Millisecond), err)
+		}
+		r.lock.Lock()
+		r.results[input.FunctionID] = result
+		if len(result.Errors) == 0 && result.Metafile != "" {
+			r.metafiles[input.FunctionID] = result.Metafile
+		}
+		r.cfgPath = input.CfgPath
+		r.touch(input.FunctionID)
+		r.lock.Unlock()
+	}
 	errors := []string{}
+	detailedErrors := []runtime.BuildError{}
 	for _, error := range result.Errors {
 		text := error.Text
-		if error.Location != nil {
-			text = text + " " + error.Location.File + ":" + fmt.Sprint(error.Location.Line) + ":" + fmt.Sprint(error.Location.Column)
+		if match := missingLoaderRegex.FindStringSubmatch(text); match != nil {
+			text = fmt.Sprintf(`%v, add loader: { "%v": "file" } to your function's loader config`, text, match[1])
 		}
-		errors = append(errors, text)
+		if match := unresolvedRegex.FindStringSubmatch(text); match != nil && platform != esbuild.PlatformNode && isNodeBuiltin(match[1]) {
+			text = fmt.Sprintf(`%v, node builtins are not available on the %v platform, polyfill it with alias: { "%v": "<polyfill>" } or add it to external if the runtime provides it`, text, properties.Platform, match[1])
+		}
+		detailed := buildError(error)
+		detailed.Text = text
+		detailedErrors = append(detailedErrors, detailed)
+		errors = append(errors, messageText(error, text))
+	}
+	// Warnings and DetailedWarnings cover the same messages, the runtime's own
+	// warnings first followed by esbuild's.
+	detailedWarnings := []runtime.BuildError{}
+	for _, warning := range warnings {
+		detailedWarnings = append(detailedWarnings, runtime.BuildError{Text: warning})
+	}
+	for _, warning := range result.Warnings {
+		detailedWarnings = append(detailedWarnings, buildError(warning))
+		warnings = append(warnings, messageText(warning, warning.Text))
 	}
 	for _, error := range result.Errors {
-		slog.Error("esbuild error", "error", error)
+		slog.Error("esbuild error", "functionID", input.FunctionID, "handler", input.Handler, "error", error)
 	}
 	for _, warning := range result.Warnings {
-		slog.Error("esbuild error", "error", warning)
+		slog.Warn("esbuild warning", "functionID", input.FunctionID, "handler", input.Handler, "warning", warning)
+	}
+
+	var metafile js.Metafile
+	json.Unmarshal([]byte(result.Metafile), &metafile)
+
+	// Only outputs of the handler files count as entries, splitting also
+	// turns dynamic imports into entry points.
+	entries := map[string]bool{}
+	for _, file := range files {
+		source, _ := filepath.Abs(file)
+		entries[source] = true
+	}
+	for key, output := range metafile.Outputs {
+		if output.Entrypoint == "" || filepath.Ext(key) == ".css" || !entries[filepath.Join(root, output.Entrypoint)] {
+			continue
+		}
+		// Resolve the handler from the entry's actual output, which may differ
+		// from the source path with splitting or custom entry names.
+		if len(files) == 1 {
+			rel, err := filepath.Rel(input.Out(), filepath.Join(root, key))
+			if err == nil {
+				handler = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))) + "." + handlerExport(input.Handler, file)
+			}
+		}
+		// CommonJS output does not record its exports in the metafile.
+		if !isESM || len(result.Errors) > 0 {
+			continue
+		}
+		name := strings.Split(handlerExport(input.Handler, output.Entrypoint), ".")[0]
+		if slices.Contains(output.Exports, name) {
+			continue
+		}
+		text := fmt.Sprintf("handler export %q not found in %v, found: %v", name, output.Entrypoint, strings.Join(output.Exports, ", "))
+		errors = append(errors, text)
+		detailedErrors = append(detailedErrors, runtime.BuildError{Text: text, File: output.Entrypoint})
+	}
+
+	externals := externalPackages(metafile)
+	assets := stylesheets(metafile, root)
+	inputs := inputFiles(metafile, root)
+
+	if properties.DetectCircularImports {
+		for _, cycle := range findCycles(metafile) {
+			warning := "circular import between " + strings.Join(cycle, ", ")
+			warnings = append(warnings, warning)
+			detailedWarnings = append(detailedWarnings, runtime.BuildError{Text: warning})
+		}
+	}
+
+	if dry {
+		sizes, size := outputSizes(metafile, root, input.Out())
+		outputFiles := []runtime.OutputFile{}
+		for _, outputFile := range result.OutputFiles {
+			rel, err := filepath.Rel(input.Out(), outputFile.Path)
+			if err != nil {
+				return nil, err
+			}
+			outputFiles = append(outputFiles, runtime.OutputFile{
+				Path:     rel,
+				Contents: outputFile.Contents,
+			})
+		}
+		return &runtime.BuildOutput{
+			Handler:          handler,
+			Entry:            entry,
+			Errors:           errors,
+			Warnings:         warnings,
+			DetailedErrors:   detailedErrors,
+			DetailedWarnings: detailedWarnings,
+			Size:             size,
+			Sizes:            sizes,
+			Assets:           assets,
+			Inputs:           inputs,
+			Externals:        externals,
+			Duration:         duration,
+			Metafile:         result.Metafile,
+			OutputFiles:      outputFiles,
+		}, nil
+	}
+
+	if properties.WriteMetafile && result.Metafile != "" {
+		err := os.WriteFile(filepath.Join(input.Out(), "metafile.json"), []byte(result.Metafile), 0644)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if input.Dev {
 		nodeModules, err := fs.FindUp(file, "node_modules")
-		if err == nil {
-			os.Symlink(nodeModules, filepath.Join(input.Out(), "node_modules"))
+		if err != nil {
+			if properties.BundleDependencies != nil && !*properties.BundleDependencies {
+				return nil, fmt.Errorf("no node_modules found above %v, dependencies are not bundled so they must be installed", file)
+			}
+			warning := fmt.Sprintf("no node_modules found above %v, external dependencies will not resolve at runtime", file)
+			slog.Warn(warning, "functionID", input.FunctionID, "handler", input.Handler)
+			warnings = append(warnings, warning)
+			detailedWarnings = append(detailedWarnings, runtime.BuildError{Text: warning})
+		} else {
+			linkDir := input.Out()
+			if scratch != "" {
+				linkDir = scratch
+			}
+			if err := os.MkdirAll(linkDir, 0755); err != nil {
+				return nil, err
+			}
+			if err := ensureLink(nodeModules, filepath.Join(linkDir, "node_modules")); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if !input.Dev {
-		var metafile js.Metafile
-		json.Unmarshal([]byte(result.Metafile), &metafile)
-
+	if !input.Dev && !properties.SkipInstall {
 		installPackages := properties.Install
-		for _, pkg := range forceExternal {
-			if slices.Contains(properties.ESBuild.External, pkg) {
+		for _, pkg := range defaultExternal {
+			if strings.HasSuffix(pkg, "*") || slices.Contains(properties.ESBuild.External, pkg) {
 				continue
 			}
 			for _, input := range metafile.Inputs {
 				for _, imp := range input.Imports {
-					if imp.Kind == "external" && imp.Path == pkg {
+					if imp.External && imp.Path == pkg {
 						installPackages = append(installPackages, pkg)
 					}
 				}
@@ -198,6 +833,9 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 					dependencies[pkg] = parsed.Dependencies[pkg]
 				}
 			}
+			if err := os.MkdirAll(input.Out(), 0755); err != nil {
+				return nil, err
+			}
 			outPkg := filepath.Join(input.Out(), "package.json")
 			outFile, err := os.Create(outPkg)
 			if err != nil {
@@ -223,15 +861,349 @@ func (r *Runtime) Build(ctx context.Context, input *runtime.BuildInput) (*runtim
 			if slices.Contains(installPackages, "sharp") {
 				cmd = append(cmd, "--libc=glibc")
 			}
-			err = exec.Command("npm", cmd...).Run()
+			specs := []string{}
+			for pkg, version := range dependencies {
+				specs = append(specs, pkg+"@"+version)
+			}
+			sort.Strings(specs)
+			cmd = append(cmd, specs...)
+			install := exec.Command(r.options.Installer, cmd...)
+			install.Dir = input.Out()
+			if scratch != "" {
+				install.Env = append(os.Environ(), "npm_config_cache="+filepath.Join(scratch, "cache"))
+			}
+			output, err := install.CombinedOutput()
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("failed to install %v: %w\n%s", strings.Join(specs, ", "), err, output)
+			}
+		}
+	}
+
+	if properties.TypeModule && isESM && extension != ".mjs" && len(errors) == 0 {
+		if err := writeTypeModule(input.Out()); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(properties.Copy) > 0 && len(errors) == 0 {
+		if err := runtime.CopyFiles(root, input.Out(), properties.Copy, false); err != nil {
+			return nil, err
+		}
+	}
+
+	postBuildOutput := ""
+	if properties.PostBuild != "" && len(errors) == 0 {
+		postBuildOutput, err = r.postBuild(ctx, input, properties.PostBuild)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sizes, size := outputSizes(metafile, root, input.Out())
+	outputs := make([]string, 0, len(sizes))
+	for file := range sizes {
+		outputs = append(outputs, file)
+	}
+	hash, err := hashOutputs(input.Out(), outputs)
+	if err != nil {
+		return nil, err
+	}
+	archive := ""
+	if properties.Archive {
+		archive = input.Out() + ".zip"
+		if err := archiveDir(input.Out(), archive); err != nil {
+			return nil, err
+		}
+	}
+
+	sourceMap := ""
+	if rel, err := filepath.Rel(input.Out(), target+".map"); err == nil {
+		if _, ok := sizes[rel]; ok {
+			sourceMap = target + ".map"
+		}
+	}
+
+	output := &runtime.BuildOutput{
+		Handler:          handler,
+		Entry:            entry,
+		Errors:           errors,
+		Warnings:         warnings,
+		DetailedErrors:   detailedErrors,
+		DetailedWarnings: detailedWarnings,
+		Size:             size,
+		Sizes:            sizes,
+		Hash:             hash,
+		SourceMap:        sourceMap,
+		Archive:          archive,
+		Assets:           assets,
+		Inputs:           inputs,
+		Externals:        externals,
+		PostBuildOutput:  postBuildOutput,
+		Incremental:      incremental,
+		Duration:         duration,
+	}
+	r.recordBuild(input.FunctionID, duration, size)
+	if len(errors) == 0 {
+		r.storeBuild(input, output)
+	} else {
+		r.lock.Lock()
+		delete(r.builds, input.FunctionID)
+		r.lock.Unlock()
+	}
+	return output, nil
+}
+
+// postBuild runs the function's post build command in its output directory
+// and returns the combined output.
+func (r *Runtime) postBuild(ctx context.Context, input *runtime.BuildInput, command string) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	if goruntime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Dir = input.Out()
+	cmd.Env = append(os.Environ(),
+		"SST_FUNCTION_ID="+input.FunctionID,
+		"SST_HANDLER="+input.Handler,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("post build command for function %v failed: %w\n%s", input.FunctionID, err, output)
+	}
+	return string(output), nil
+}
+
+// warm creates the function's build context unless it already has one.
+func (r *Runtime) warm(functionID string, options esbuild.BuildOptions) error {
+	r.lock.RLock()
+	_, ok := r.contexts[functionID]
+	r.lock.RUnlock()
+	if ok {
+		return nil
+	}
+	buildContext, err := r.newContext(options)
+	if err != nil {
+		texts := []string{}
+		for _, message := range err.Errors {
+			texts = append(texts, message.Text)
+		}
+		return fmt.Errorf("%v", strings.Join(texts, ", "))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.contexts[functionID]; ok {
+		buildContext.Dispose()
+		return nil
+	}
+	r.contexts[functionID] = buildContext
+	r.optionKeys[functionID] = optionsKey(options)
+	r.touch(functionID)
+	return nil
+}
+
+// optionsKey identifies the options a build context is created with. Plugins
+// hold functions, so only their names count.
+func optionsKey(options esbuild.BuildOptions) string {
+	plugins := []string{}
+	for _, plugin := range options.Plugins {
+		plugins = append(plugins, plugin.Name)
+	}
+	options.Plugins = nil
+	data, err := json.Marshal(struct {
+		Options esbuild.BuildOptions
+		Plugins []string
+	}{options, plugins})
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// transientErrors are esbuild failures that go away once the build context
+// is recreated.
+var transientErrors = []string{
+	"service is no longer running",
+	"service was stopped",
+}
+
+// buildRetryBackoff is the delay before the first retry of a transient build
+// failure, doubling for every retry after that.
+var buildRetryBackoff = 100 * time.Millisecond
+
+// rebuild runs the function's cached build context, creating it if needed.
+// Transient failures dispose the context and retry with backoff up to
+// Options.BuildAttempts times, build errors in the code are returned as is.
+// It also reports whether the final attempt reused an existing context and
+// how long its Rebuild took. When ctx is done first the build is cancelled,
+// the context is dropped, and ctx's error is returned.
+func (r *Runtime) rebuild(ctx context.Context, functionID string, options esbuild.BuildOptions) (esbuild.BuildResult, bool, time.Duration, error) {
+	key := optionsKey(options)
+	r.lock.Lock()
+	if buildContext, ok := r.contexts[functionID]; ok && r.optionKeys[functionID] != key {
+		slog.Info("build options changed, recreating build context", "functionID", functionID)
+		buildContext.Dispose()
+		delete(r.contexts, functionID)
+	}
+	r.lock.Unlock()
+	delay := buildRetryBackoff
+	for attempt := 1; ; attempt++ {
+		r.lock.RLock()
+		buildContext, ok := r.contexts[functionID]
+		r.lock.RUnlock()
+		if !ok {
+			created, err := r.newContext(options)
+			if err != nil {
+				return esbuild.BuildResult{Errors: err.Errors}, false, 0, nil
+			}
+			buildContext = created
+			r.lock.Lock()
+			r.contexts[functionID] = buildContext
+			r.optionKeys[functionID] = optionsKey(options)
+			r.lock.Unlock()
+		}
+		start := time.Now()
+		done := make(chan esbuild.BuildResult, 1)
+		go func() {
+			done <- buildContext.Rebuild()
+		}()
+		var result esbuild.BuildResult
+		select {
+		case result = <-done:
+		case <-ctx.Done():
+			slog.Warn("esbuild did not finish, dropping context", "functionID", functionID, "err", ctx.Err())
+			r.lock.Lock()
+			if r.contexts[functionID] == buildContext {
+				delete(r.contexts, functionID)
 			}
+			r.lock.Unlock()
+			// Plugins can block esbuild past cancellation, so clean up
+			// without waiting for it.
+			go func() {
+				buildContext.Cancel()
+				buildContext.Dispose()
+			}()
+			return esbuild.BuildResult{}, ok, time.Since(start), ctx.Err()
 		}
+		duration := time.Since(start)
+		if !isTransient(result) || attempt >= r.options.BuildAttempts {
+			return result, ok, duration, nil
+		}
+		slog.Warn("esbuild failed, recreating context", "functionID", functionID, "attempt", attempt, "delay", delay)
+		r.lock.Lock()
+		if r.contexts[functionID] == buildContext {
+			delete(r.contexts, functionID)
+		}
+		r.lock.Unlock()
+		buildContext.Dispose()
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransient reports whether the build failed because of esbuild rather
+// than the code. A disposed context returns an empty result, metafiles are
+// always requested so a successful build has one.
+func isTransient(result esbuild.BuildResult) bool {
+	if len(result.Errors) == 0 {
+		return result.Metafile == ""
+	}
+	for _, error := range result.Errors {
+		for _, transient := range transientErrors {
+			if strings.Contains(error.Text, transient) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// messageText formats an esbuild message as a single line, prefixed with its
+// plugin and suffixed with its location.
+func messageText(message esbuild.Message, text string) string {
+	if message.PluginName != "" {
+		text = "[plugin " + message.PluginName + "] " + text
+	}
+	if message.Location != nil {
+		text = text + " " + message.Location.File + ":" + fmt.Sprint(message.Location.Line) + ":" + fmt.Sprint(message.Location.Column)
+	}
+	return text
+}
+
+func buildError(message esbuild.Message) runtime.BuildError {
+	result := runtime.BuildError{Text: message.Text, Plugin: message.PluginName}
+	if message.Location != nil {
+		result.File = message.Location.File
+		result.Line = message.Location.Line
+		result.Column = message.Location.Column
 	}
+	return result
+}
 
-	return &runtime.BuildOutput{
-		Handler: input.Handler,
-		Errors:  errors,
-	}, nil
+// writeTypeModule marks the directory's package.json, creating it if needed,
+// with type module so node loads its .js files as esm.
+func writeTypeModule(dir string) error {
+	file := filepath.Join(dir, "package.json")
+	pkg := map[string]interface{}{}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return fmt.Errorf("invalid package.json in output: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	pkg["type"] = "module"
+	data, err = json.Marshal(pkg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+func hasDefine(define map[string]string, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := define[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func loadDefineFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read define file %v: %w", path, err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse define file %v: %w", path, err)
+	}
+	define := map[string]string{}
+	for key, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		define[key] = string(encoded)
+	}
+	return define, nil
+}
+
+func parseTarget(input string) (esbuild.Target, []esbuild.Engine, error) {
+	value := strings.ToLower(strings.TrimSpace(input))
+	if target, ok := targetMap[value]; ok {
+		return target, nil, nil
+	}
+	if version, ok := strings.CutPrefix(value, "node"); ok && version != "" {
+		valid := true
+		for _, part := range strings.Split(version, ".") {
+			if part == "" || strings.Trim(part, "0123456789") != "" {
+				valid = false
+			}
+		}
+		if valid {
+			return esbuild.ESNext, []esbuild.Engine{{Name: esbuild.EngineNode, Version: version}}, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("unknown target %q, expected one of node16, node18, node20, es2015 through es2023, or esnext", input)
 }
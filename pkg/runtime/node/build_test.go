@@ -0,0 +1,2185 @@
+package node
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/sst/ion/internal/fs"
+	"github.com/sst/ion/pkg/js"
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupProject(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	files["sst.config.ts"] = ""
+	for name, contents := range files {
+		file := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(contents), 0644))
+	}
+	return filepath.Join(root, "sst.config.ts")
+}
+
+func buildInput(t *testing.T, cfgPath string, handler string, properties map[string]interface{}) *runtime.BuildInput {
+	raw, err := json.Marshal(properties)
+	require.NoError(t, err)
+	return &runtime.BuildInput{
+		CfgPath:    cfgPath,
+		FunctionID: "fn",
+		Handler:    handler,
+		Runtime:    "nodejs20.x",
+		Properties: raw,
+	}
+}
+
+func readOutput(t *testing.T, input *runtime.BuildInput, name string) string {
+	data, err := os.ReadFile(filepath.Join(input.Out(), name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestBuildBundleDependencies(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { value } from "dep"; export const handler = () => value;`,
+		"node_modules/dep/package.json": `{"name":"dep","main":"index.js"}`,
+		"node_modules/dep/index.js":     `exports.value = "bundled-dep";`,
+	})
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "bundled-dep")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"bundleDependencies": false,
+	})
+	out, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.NotContains(t, output, "bundled-dep")
+	assert.Contains(t, output, `from "dep"`)
+}
+
+func TestParseTarget(t *testing.T) {
+	target, engines, err := parseTarget("node18")
+	require.NoError(t, err)
+	assert.Equal(t, esbuild.ESNext, target)
+	assert.Equal(t, []esbuild.Engine{{Name: esbuild.EngineNode, Version: "18"}}, engines)
+
+	target, engines, err = parseTarget("node20.11")
+	require.NoError(t, err)
+	assert.Equal(t, esbuild.ESNext, target)
+	assert.Equal(t, "20.11", engines[0].Version)
+
+	target, engines, err = parseTarget("es2022")
+	require.NoError(t, err)
+	assert.Equal(t, esbuild.ES2022, target)
+	assert.Empty(t, engines)
+
+	target, _, err = parseTarget("ESNext")
+	require.NoError(t, err)
+	assert.Equal(t, esbuild.ESNext, target)
+
+	_, _, err = parseTarget("node")
+	assert.ErrorContains(t, err, "unknown target")
+	_, _, err = parseTarget("es5x")
+	assert.ErrorContains(t, err, "unknown target")
+}
+
+func TestBuildJSXAutomatic(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.tsx": `export const handler = () => <div>hello</div>;`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"jsx":                "automatic",
+		"jsxImportSource":    "preact",
+		"bundleDependencies": false,
+	})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `from "preact/jsx-runtime"`)
+}
+
+func TestBuildTsconfig(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":               `import { value } from "@lib/value"; export const handler = () => value;`,
+		"lib/value.ts":               `export const value = "from-alias";`,
+		"config/tsconfig.build.json": `{"compilerOptions":{"baseUrl":"..","paths":{"@lib/*":["lib/*"]}}}`,
+	})
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, out.Errors)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"tsconfig": "config/tsconfig.build.json",
+	})
+	out, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-alias")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"tsconfig": "config/missing.json",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "tsconfig not found")
+}
+
+func TestBuildAlias(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                          `import { value } from "original"; import { extra } from "original/extra"; export const handler = () => value + extra;`,
+		"node_modules/original/package.json":    `{"name":"original","main":"index.js"}`,
+		"node_modules/original/index.js":        `exports.value = "original-target";`,
+		"node_modules/original/extra.js":        `exports.extra = "original-extra";`,
+		"node_modules/replacement/package.json": `{"name":"replacement","main":"index.js"}`,
+		"node_modules/replacement/index.js":     `exports.value = "replacement-target";`,
+		"node_modules/replacement/extra.js":     `exports.extra = "replacement-extra";`,
+	})
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"alias": map[string]string{"original": "replacement"},
+	})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "replacement-target")
+	assert.Contains(t, output, "replacement-extra")
+	assert.NotContains(t, output, "original-target")
+}
+
+func TestBuildDisableDefaultExternals(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                    `import sharp from "sharp"; export const handler = () => sharp;`,
+		"node_modules/sharp/package.json": `{"name":"sharp","main":"index.js"}`,
+		"node_modules/sharp/index.js":     `module.exports = "forked-sharp";`,
+	})
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `from "sharp"`)
+	assert.NotContains(t, output, "forked-sharp")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"defaultExternals": []string{},
+	})
+	input.Dev = true
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "forked-sharp")
+}
+
+func TestBuildOutputCase(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/Foo.ts": `export const handler = () => "foo";`,
+	})
+	r := New()
+
+	input := buildInput(t, cfgPath, "src/Foo.handler", map[string]interface{}{
+		"lowercaseOutput": true,
+	})
+	input.FunctionID = "lower"
+	out, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, "src/foo.handler", out.Handler)
+	assert.FileExists(t, filepath.Join(input.Out(), "src/foo.mjs"))
+
+	input = buildInput(t, cfgPath, "src/Foo.handler", map[string]interface{}{})
+	input.FunctionID = "FN"
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	input.FunctionID = "fn"
+	_, err = r.Build(context.Background(), input)
+	assert.ErrorContains(t, err, "collides")
+
+	// Renaming a handler replaces the function's claim instead of colliding
+	// with its own earlier output.
+	root := filepath.Dir(cfgPath)
+	require.NoError(t, os.Rename(filepath.Join(root, "src/Foo.ts"), filepath.Join(root, "src/foo.ts")))
+	input = buildInput(t, cfgPath, "src/foo.handler", map[string]interface{}{})
+	input.FunctionID = "FN"
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	r.Reset("FN")
+	input.FunctionID = "fn"
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+
+	// Handlers matched by one glob land in the same directory.
+	require.NoError(t, os.WriteFile(filepath.Join(root, "src/Foo.ts"), []byte(`export const handler = () => "Foo";`), 0644))
+	input = buildInput(t, cfgPath, "src/*.handler", map[string]interface{}{})
+	input.FunctionID = "glob"
+	_, err = r.Build(context.Background(), input)
+	assert.ErrorContains(t, err, "collides")
+	assert.ErrorContains(t, err, filepath.Join(root, "src/Foo.ts"))
+	assert.ErrorContains(t, err, filepath.Join(root, "src/foo.ts"))
+}
+
+func TestBuildProperties(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minfy": true,
+	})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`ignoring function properties: unknown field "minfy"`}, out.Warnings)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify": "yes",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "invalid function properties")
+	assert.ErrorContains(t, err, "minify")
+}
+
+func TestBuildESBuildEnums(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import "left-pad"; export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"esbuild": map[string]interface{}{
+			"target":   "es2020",
+			"external": []string{"left-pad"},
+		},
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	require.Len(t, result.Warnings, 1)
+	assert.Contains(t, result.Warnings[0], "ignoring esbuild options")
+	assert.Contains(t, result.Warnings[0], "BuildOptions.target")
+	assert.Contains(t, result.Externals, "left-pad")
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `"left-pad"`)
+}
+
+func TestBuildNodeModules(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `export const handler = () => "ok";`,
+		"node_modules/dep/package.json": `{"name":"dep"}`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(input.Out(), "node_modules/dep/package.json"))
+
+	link := filepath.Join(input.Out(), "node_modules")
+	require.NoError(t, os.Remove(link))
+	require.NoError(t, os.Symlink(t.TempDir(), link))
+	r := New()
+	for i := 0; i < 2; i++ {
+		_, err = r.Build(context.Background(), input)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(link, "dep/package.json"))
+	}
+
+	// the fallback used on Windows when symlinks and junctions are unavailable
+	copied := filepath.Join(t.TempDir(), "node_modules")
+	require.NoError(t, runtime.CopyDir(filepath.Join(input.Out(), "node_modules"), copied))
+	assert.FileExists(t, filepath.Join(copied, "dep/package.json"))
+}
+
+func TestBuildWriteMetafile(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"writeMetafile": true,
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+
+	var metafile js.Metafile
+	require.NoError(t, json.Unmarshal([]byte(readOutput(t, input, "metafile.json")), &metafile))
+	assert.Contains(t, metafile.Inputs, "src/index.ts")
+}
+
+func TestBuildSize(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(input.Out(), "src/index.mjs"))
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), out.Sizes["src/index.mjs"])
+	assert.Contains(t, out.Sizes, "src/index.mjs.map")
+	assert.Greater(t, out.Size, info.Size())
+	assert.Less(t, out.Size, int64(10_000))
+}
+
+func TestBuildHash(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "one";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	first, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	second, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.Hash)
+	assert.Equal(t, first.Hash, second.Hash)
+
+	file := filepath.Join(filepath.Dir(cfgPath), "src/index.ts")
+	require.NoError(t, os.WriteFile(file, []byte(`export const handler = () => "two";`), 0644))
+	third, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Hash, third.Hash)
+}
+
+func TestBuildDry(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `export const handler = () => "dry";`,
+		"node_modules/dep/package.json": `{"name":"dep"}`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	out, err := New().BuildDry(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.NoDirExists(t, input.Out())
+
+	var metafile js.Metafile
+	require.NoError(t, json.Unmarshal([]byte(out.Metafile), &metafile))
+	assert.Contains(t, metafile.Inputs, "src/index.ts")
+	paths := []string{}
+	for _, file := range out.OutputFiles {
+		paths = append(paths, file.Path)
+		if file.Path == "src/index.mjs" {
+			assert.Contains(t, string(file.Contents), "dry")
+		}
+	}
+	assert.ElementsMatch(t, []string{"src/index.mjs", "src/index.mjs.map"}, paths)
+}
+
+func TestBuildBannerFooter(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `import "./style.css"; export const handler = () => "ok";`,
+		"src/style.css": `body { color: red; }`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"banner": map[string]string{"js": "// js banner", "css": "/* css banner */"},
+		"footer": map[string]string{"js": "// js footer"},
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	js := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, js, "// js banner")
+	assert.Contains(t, js, "// js footer")
+	assert.Contains(t, js, "createRequire")
+	assert.True(t, strings.HasPrefix(readOutput(t, input, "src/index.css"), "/* css banner */"))
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"banner": "// string banner",
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "// string banner")
+}
+
+func TestBuildLegalComments(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": "/*! @license MIT license text */\nexport const handler = () => \"ok\";",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "MIT license text")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"legalComments": "none",
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotContains(t, readOutput(t, input, "src/index.mjs"), "MIT license text")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"legalComments": "external",
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs.LEGAL.txt"), "MIT license text")
+}
+
+func TestBuildInject(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":    `export const handler = () => shimmed();`,
+		"shims/global.ts": `export function shimmed() { return "from-shim"; }`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"inject": []string{"shims/global.ts"},
+	})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-shim")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"inject": []string{"shims/missing.ts"},
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "inject file not found")
+}
+
+func TestBuildTreeShaking(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import "./register"; export const handler = () => "ok";`,
+		"src/register.ts": `globalThis.registered = "side-effect";
+export function unusedHelper() { return "unused-helper"; }`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	out := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, out, "side-effect")
+	assert.NotContains(t, out, "unused-helper")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"treeShaking": false,
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	out = readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, out, "side-effect")
+	assert.Contains(t, out, "unused-helper")
+}
+
+func TestBuildAssetNames(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import logo from "./logo.png"; export const handler = () => logo;`,
+		"src/logo.png": "png",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"loader":     map[string]string{".png": "file"},
+		"assetNames": "[name]-[hash]",
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	assets := []string{}
+	for file := range result.Sizes {
+		if strings.HasSuffix(file, ".png") {
+			assets = append(assets, file)
+		}
+	}
+	require.Len(t, assets, 1)
+	assert.Regexp(t, `^src/logo-[A-Z0-9]{8}\.png$`, filepath.ToSlash(assets[0]))
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), filepath.Base(assets[0]))
+}
+
+func TestBuildPublicPath(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import logo from "./logo.png"; export const handler = () => logo;`,
+		"src/logo.png": "png",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"loader":     map[string]string{".png": "file"},
+		"publicPath": "https://cdn.example.com/",
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Regexp(t, `"https://cdn\.example\.com/logo-[A-Z0-9]{8}\.png"`, readOutput(t, input, "src/index.mjs"))
+}
+
+func TestBuildUnknownLoader(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"loader": map[string]string{".png": "file", ".jpg": "fil"},
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 1)
+	assert.Contains(t, result.Warnings[0], `unknown loader "fil" for ".jpg"`)
+	assert.Contains(t, result.Warnings[0], "dataurl")
+}
+
+func TestBuildInstall(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("stub installer is a shell script")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"package.json": `{"dependencies":{"left-pad":"^1.3.0"}}`,
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	installer := filepath.Join(t.TempDir(), "installer")
+	require.NoError(t, os.WriteFile(installer, []byte("#!/bin/sh\necho \"$@\" > args\n"), 0755))
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"install": []string{"left-pad", "is-odd"},
+	})
+	_, err := New(WithInstaller(installer)).Build(context.Background(), input)
+	require.NoError(t, err)
+	args := strings.Fields(readOutput(t, input, "args"))
+	assert.Equal(t, "install", args[0])
+	assert.Contains(t, args, "--arch=x64")
+	assert.Equal(t, []string{"is-odd@*", "left-pad@^1.3.0"}, args[len(args)-2:])
+	require.NoError(t, os.Remove(filepath.Join(input.Out(), "args")))
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"install":     []string{"left-pad"},
+		"skipInstall": true,
+	})
+	_, err = New(WithInstaller(installer)).Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(input.Out(), "args"))
+}
+
+func TestBuildCharset(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "héllo 👋";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotContains(t, readOutput(t, input, "src/index.mjs"), "héllo 👋")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"charset": "utf8",
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "héllo 👋")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"charset": "latin1",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, `unknown charset "latin1"`)
+}
+
+func TestBuildDetailedErrors(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": "export const handler = () => {\n  return missing(;\n};\n",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	require.Len(t, result.DetailedErrors, 1)
+	detailed := result.DetailedErrors[0]
+	assert.Equal(t, "src/index.ts", detailed.File)
+	assert.Equal(t, 2, detailed.Line)
+	assert.Equal(t, 17, detailed.Column)
+	assert.Equal(t, `Unexpected ";"`, detailed.Text)
+	assert.Contains(t, result.Errors[0], "src/index.ts:2:17")
+}
+
+func TestBuildPure(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `declare function createLogger(name: string): unknown;
+const logger = createLogger("unused-logger");
+export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify": true,
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "unused-logger")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify": true,
+		"pure":   []string{"createLogger"},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotContains(t, readOutput(t, input, "src/index.mjs"), "unused-logger")
+}
+
+func TestBuildConditions(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { mode } from "lib"; export const handler = () => mode;`,
+		"node_modules/lib/package.json": `{"name":"lib","exports":{"development":"./dev.js","default":"./prod.js"}}`,
+		"node_modules/lib/dev.js":       `export const mode = "from-development";`,
+		"node_modules/lib/prod.js":      `export const mode = "from-default";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-default")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"conditions": []string{"development"},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-development")
+	assert.NotContains(t, output, "from-default")
+}
+
+func TestBuildPlatform(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { target } from "lib"; import sharp from "sharp"; export const handler = () => [target, sharp];`,
+		"node_modules/lib/package.json": `{"name":"lib","main":"./node.js","browser":"./browser.js"}`,
+		"node_modules/lib/node.js":      `export const target = "from-node";`,
+		"node_modules/lib/browser.js":   `export const target = "from-browser";`,
+		"node_modules/sharp/index.js":   `export default "sharp-bundled";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"skipInstall": true,
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-node")
+	assert.Contains(t, output, "topLevelCreateRequire")
+	assert.NotContains(t, output, "sharp-bundled")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"platform": "browser",
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output = readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-browser")
+	assert.NotContains(t, output, "from-node")
+	assert.NotContains(t, output, "topLevelCreateRequire")
+	assert.Contains(t, output, "sharp-bundled")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"platform": "deno",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, `unknown platform "deno"`)
+}
+
+func TestBuildResolveExtensions(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":    `import { name } from "./foo"; export const handler = () => name;`,
+		"src/foo.ts":      `export const name = "from-default";`,
+		"src/foo.node.ts": `export const name = "from-node-variant";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-default")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"resolveExtensions": []string{".node.ts", ".ts"},
+		"esbuild": map[string]interface{}{
+			"ResolveExtensions": []string{".ts"},
+		},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-node-variant")
+	assert.NotContains(t, output, "from-default")
+}
+
+func TestBuildNodePaths(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":               `import { shared } from "shared"; export const handler = () => shared;`,
+		"vendor/shared/package.json": `{"name":"shared","main":"index.js"}`,
+		"vendor/shared/index.js":     `export const shared = "from-vendor";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Errors)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"nodePaths": []string{"vendor"},
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-vendor")
+}
+
+func TestBuildSupported(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = (event: any) => event?.body;`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "event?.body")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"supported": map[string]bool{"optional-chain": false},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.NotContains(t, output, "event?.body")
+	assert.Contains(t, output, "event == null ? void 0 : event.body")
+}
+
+func TestBuildIgnoreAnnotations(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import "lib"; export const handler = () => "ok";`,
+		"node_modules/lib/package.json": `{"name":"lib","main":"index.js"}`,
+		"node_modules/lib/index.js":     `/* @__PURE__ */ globalThis.register("needed-registration");`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotContains(t, readOutput(t, input, "src/index.mjs"), "needed-registration")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"ignoreAnnotations": true,
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "needed-registration")
+}
+
+func TestBuildSourceMap(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"sourceMap": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(input.Out(), "src/index.mjs.map"), result.SourceMap)
+	data, err := os.ReadFile(result.SourceMap)
+	require.NoError(t, err)
+	var sourceMap struct {
+		Version int      `json:"version"`
+		Sources []string `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(data, &sourceMap))
+	assert.Equal(t, 3, sourceMap.Version)
+	assert.NotEmpty(t, sourceMap.Sources)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, result.SourceMap)
+}
+
+func TestBuildArchive(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { name } from "lib"; export const handler = () => name;`,
+		"node_modules/lib/package.json": `{"name":"lib","main":"index.js"}`,
+		"node_modules/lib/index.js":     `export const name = "lib";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"archive":            true,
+		"bundleDependencies": false,
+	})
+	input.Dev = true
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, input.Out()+".zip", result.Archive)
+
+	reader, err := zip.OpenReader(result.Archive)
+	require.NoError(t, err)
+	defer reader.Close()
+	names := []string{}
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		assert.Equal(t, archiveTime, file.Modified.UTC())
+	}
+	assert.Contains(t, names, "src/index.mjs")
+	assert.Contains(t, names, "node_modules/lib/index.js")
+	assert.IsIncreasing(t, names)
+
+	first, err := os.ReadFile(result.Archive)
+	require.NoError(t, err)
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	second, err := os.ReadFile(result.Archive)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+}
+
+func TestBuildDefaultExternals(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import { S3 } from "@aws-sdk/client-s3";
+import sharp from "sharp";
+export const handler = () => [S3, sharp];`,
+		"node_modules/@aws-sdk/client-s3/package.json": `{"name":"@aws-sdk/client-s3","main":"index.js"}`,
+		"node_modules/@aws-sdk/client-s3/index.js":     `export const S3 = "bundled-s3";`,
+		"node_modules/sharp/package.json":              `{"name":"sharp","main":"index.js"}`,
+		"node_modules/sharp/index.js":                  `module.exports = "bundled-sharp";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"defaultExternals": []string{"@aws-sdk/*"},
+	})
+	result, err := New(WithInstaller(filepath.Join(t.TempDir(), "missing"))).Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `from "@aws-sdk/client-s3"`)
+	assert.NotContains(t, output, "bundled-s3")
+	assert.Contains(t, output, "bundled-sharp")
+}
+
+func TestBuildMissingNodeModules(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	if _, err := fs.FindUp(cfgPath, "node_modules"); err == nil {
+		t.Skip("temp dir is inside a node_modules tree")
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 1)
+	assert.Contains(t, result.Warnings[0], "no node_modules found")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"bundleDependencies": false,
+	})
+	input.Dev = true
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "no node_modules found")
+}
+
+func TestBuildHandlerExport(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok"; export const nested = { handler: () => "nested" };`,
+	})
+
+	result, err := New().Build(context.Background(), buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{}))
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, "src/index.handler", result.Handler)
+
+	result, err = New().Build(context.Background(), buildInput(t, cfgPath, "src/index.nested.handler", map[string]interface{}{}))
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, "src/index.nested.handler", result.Handler)
+
+	result, err = New().Build(context.Background(), buildInput(t, cfgPath, "src/index.missing", map[string]interface{}{}))
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, `handler export "missing" not found in src/index.ts, found: handler, nested`, result.Errors[0])
+}
+
+func TestHandlerExport(t *testing.T) {
+	assert.Equal(t, "handler", handlerExport("src/index.handler", "src/index.ts"))
+	assert.Equal(t, "nested.handler", handlerExport("src/index.nested.handler", "/root/src/index.ts"))
+	assert.Equal(t, "handler", handlerExport("src/index.test.handler", "src/index.test.ts"))
+	assert.Equal(t, "handler", handlerExport("src/handlers/*.handler", "src/handlers/a.ts"))
+}
+
+func TestBuildJSXDev(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.tsx":                   `export const handler = () => <div>hello</div>;`,
+		"node_modules/react/package.json": `{"name":"react"}`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"jsx":                "automatic",
+		"jsxDev":             true,
+		"bundleDependencies": false,
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `from "react/jsx-dev-runtime"`)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"jsx":                "automatic",
+		"bundleDependencies": false,
+	})
+	input.Dev = true
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `from "react/jsx-dev-runtime"`)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"jsx":                "automatic",
+		"jsxDev":             false,
+		"bundleDependencies": false,
+	})
+	input.Dev = true
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `from "react/jsx-runtime"`)
+}
+
+func TestBuildSplitting(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = async () => (await import("./shared")).shared;`,
+		"src/shared.ts": `export const shared = "shared";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"splitting": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, "src/index.handler", result.Handler)
+	outputs := []string{}
+	for file := range result.Sizes {
+		if strings.HasSuffix(file, ".mjs") {
+			outputs = append(outputs, file)
+		}
+	}
+	assert.Greater(t, len(outputs), 1)
+	assert.FileExists(t, filepath.Join(input.Out(), "src/index.mjs"))
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"splitting":  true,
+		"entryNames": "[dir]/[name]-[hash]",
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Regexp(t, `^src/index-[A-Z0-9]{8}\.handler$`, result.Handler)
+	assert.FileExists(t, filepath.Join(input.Out(), strings.TrimSuffix(result.Handler, ".handler")+".mjs"))
+}
+
+func TestBuildExternals(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("stub installer is a shell script")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"package.json": `{"dependencies":{"sharp":"^0.33.0"}}`,
+		"src/index.ts": `import sharp from "sharp";
+import get from "@scope/utils/get";
+import fs from "node:fs";
+import path from "path";
+export const handler = () => [sharp, get, fs, path];`,
+	})
+	installer := filepath.Join(t.TempDir(), "installer")
+	require.NoError(t, os.WriteFile(installer, []byte("#!/bin/sh\necho \"$@\" > args\n"), 0755))
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"esbuild": map[string]interface{}{
+			"External": []string{"@scope/*"},
+		},
+	})
+	result, err := New(WithInstaller(installer)).Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, []string{"@scope/utils", "sharp"}, result.Externals)
+	assert.Contains(t, readOutput(t, input, "args"), "sharp@^0.33.0")
+}
+
+type failingContext struct {
+	esbuild.BuildContext
+}
+
+func (c failingContext) Rebuild() esbuild.BuildResult {
+	return esbuild.BuildResult{Errors: []esbuild.Message{{Text: "The service is no longer running"}}}
+}
+
+func (c failingContext) Dispose() {}
+
+func TestBuildRetry(t *testing.T) {
+	previous := buildRetryBackoff
+	buildRetryBackoff = time.Millisecond
+	t.Cleanup(func() { buildRetryBackoff = previous })
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = () => "ok";`,
+		"src/broken.ts": `export const handler = ( => ;`,
+	})
+	r := New()
+	created := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		created++
+		if created == 1 {
+			return failingContext{}, nil
+		}
+		return esbuild.Context(options)
+	}
+	result, err := r.Build(context.Background(), buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{}))
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, 2, created)
+
+	input := buildInput(t, cfgPath, "src/broken.handler", map[string]interface{}{})
+	input.FunctionID = "broken"
+	result, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Errors)
+	assert.Equal(t, 3, created)
+}
+
+func TestBuildKeepNames(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `class Processor { run() { return "ok"; } }
+function createProcessor() { return new Processor(); }
+export const handler = () => createProcessor().run();`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify": true,
+	})
+	kept, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `"Processor"`)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify":    true,
+		"keepNames": false,
+	})
+	mangled, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotContains(t, readOutput(t, input, "src/index.mjs"), `"Processor"`)
+	assert.Less(t, mangled.Size, kept.Size)
+}
+
+type countingContext struct {
+	esbuild.BuildContext
+	rebuilds *int
+}
+
+func (c countingContext) Rebuild() esbuild.BuildResult {
+	*c.rebuilds++
+	return c.BuildContext.Rebuild()
+}
+
+func TestBuildCache(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `import { shared } from "./shared"; export const handler = () => shared;`,
+		"src/shared.ts": `export const shared = "one";`,
+	})
+	r := New()
+	rebuilds := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		buildContext, err := esbuild.Context(options)
+		if err != nil {
+			return nil, err
+		}
+		return countingContext{buildContext, &rebuilds}, nil
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+
+	first, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	second, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rebuilds)
+	assert.Equal(t, first.Hash, second.Hash)
+
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cfgPath), "src/shared.ts"), []byte(`export const shared = "two";`), 0644))
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rebuilds)
+	unminified := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, unminified, `var shared = "two";`)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minify": true,
+	})
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rebuilds)
+	minified := readOutput(t, input, "src/index.mjs")
+	assert.NotContains(t, minified, `var shared = "two";`)
+	assert.Less(t, len(minified), len(unminified))
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, readOutput(t, input, "src/index.mjs"), minified)
+
+	require.NoError(t, os.RemoveAll(input.Out()))
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 4, rebuilds)
+}
+
+func TestCollectionBuildCache(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"package.json":  `{"dependencies":{}}`,
+		"tsconfig.json": `{}`,
+		"define.json":   `{"VALUE":"one"}`,
+		"schema.json":   `{"version":1}`,
+		"src/index.ts":  `export const handler = () => "ok";`,
+	})
+	root := filepath.Dir(cfgPath)
+	r := New()
+	rebuilds := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		buildContext, err := esbuild.Context(options)
+		if err != nil {
+			return nil, err
+		}
+		return countingContext{buildContext, &rebuilds}, nil
+	}
+	collection := runtime.NewCollection(cfgPath, r)
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"defineFile": "define.json",
+		"copy":       []map[string]string{{"from": "schema.json"}},
+	})
+
+	first, err := collection.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, first.Errors)
+	second, err := collection.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rebuilds)
+	assert.True(t, second.Incremental)
+	assert.Equal(t, input.Out(), second.Out)
+	assert.Equal(t, first.Hash, second.Hash)
+	readOutput(t, input, "src/index.mjs")
+	readOutput(t, input, "schema.json")
+
+	for i, file := range []string{"define.json", "tsconfig.json", "schema.json", "package.json"} {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, file), append(data, ' '), 0644))
+		_, err = collection.Build(context.Background(), input)
+		require.NoError(t, err, file)
+		assert.Equal(t, i+2, rebuilds, file)
+		_, err = collection.Build(context.Background(), input)
+		require.NoError(t, err, file)
+		assert.Equal(t, i+2, rebuilds, file)
+	}
+}
+
+func TestBuildAssets(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/styles.css": `.title { color: red; }`,
+		"src/index.ts":   "import \"./styles.css\";\nexport const handler = () => \"ok\";",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"banner": map[string]string{"css": "/* banner */"},
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, []string{filepath.Join(input.Out(), "src/index.css")}, result.Assets)
+	data, err := os.ReadFile(result.Assets[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "/* banner */")
+	assert.Contains(t, string(data), ".title")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err = New().BuildDry(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(input.Out(), "src/index.css")}, result.Assets)
+}
+
+func TestBuildBundle(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/util.ts":  `export const greet = (name: string) => "hello " + name;`,
+		"src/index.ts": "import { greet } from \"./util\";\nimport sharp from \"sharp\";\nexport const handler = () => greet(typeof sharp);",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"bundle":      false,
+		"skipInstall": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `from "./util"`)
+	assert.Contains(t, output, `from "sharp"`)
+	assert.NotContains(t, output, "hello ")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"bundle":    false,
+		"splitting": true,
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "splitting requires bundling")
+}
+
+func TestBuildLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = (x: number) => x === -0;`,
+		"src/broken.ts": `export const handler = () => {`,
+	})
+	r := New()
+	var logLevel esbuild.LogLevel
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		logLevel = options.LogLevel
+		return esbuild.Context(options)
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"logLevel": "silent",
+	})
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.DetailedWarnings)
+	assert.Equal(t, esbuild.LogLevelSilent, logLevel)
+
+	input = buildInput(t, cfgPath, "src/broken.handler", map[string]interface{}{})
+	input.FunctionID = "broken"
+	result, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Errors)
+
+	levels := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if msg, ok := record["msg"].(string); ok && strings.HasPrefix(msg, "esbuild") {
+			levels[msg] = record["level"].(string)
+		}
+	}
+	assert.Equal(t, map[string]string{"esbuild warning": "WARN", "esbuild error": "ERROR"}, levels)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"logLevel": "debug",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, `unknown logLevel "debug"`)
+}
+
+func TestBuildMainFields(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { mode } from "lib"; export const handler = () => mode;`,
+		"node_modules/lib/package.json": `{"name":"lib","main":"./main.js","module":"./module.js"}`,
+		"node_modules/lib/main.js":      `exports.mode = "from-main";`,
+		"node_modules/lib/module.js":    `export const mode = "from-module";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "from-module")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"mainFields": []string{"main", "module"},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-main")
+	assert.NotContains(t, output, "from-module")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"format":     "cjs",
+		"mainFields": []string{"module", "main"},
+	})
+	_, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, readOutput(t, input, "src/index.cjs"), "from-module")
+}
+
+func TestBuildTargetExtension(t *testing.T) {
+	tests := []struct {
+		file     string
+		handler  string
+		expected string
+	}{
+		{"src/app.ts/index.ts", "src/app.ts/index.handler", "src/app.ts/index.mjs"},
+		{"src/a.ts.ts", "src/a.ts.handler", "src/a.ts.mjs"},
+		{"src/.ts/index.ts", "src/.ts/index.handler", "src/.ts/index.mjs"},
+	}
+	for _, test := range tests {
+		cfgPath := setupProject(t, map[string]string{
+			test.file: `export const handler = () => "ok";`,
+		})
+		input := buildInput(t, cfgPath, test.handler, map[string]interface{}{})
+		result, err := New().Build(context.Background(), input)
+		require.NoError(t, err, test.file)
+		require.Empty(t, result.Errors, test.file)
+		assert.FileExists(t, filepath.Join(input.Out(), test.expected), test.file)
+		assert.Equal(t, strings.TrimSuffix(test.expected, ".mjs")+".handler", result.Handler, test.file)
+	}
+}
+
+func TestBuildPostBuild(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("post build test uses a posix shell command")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = () => "ok";`,
+		"src/broken.ts": `export const handler = () => {`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"postBuild": `echo "$SST_FUNCTION_ID" > marker.txt && echo done`,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, "done\n", result.PostBuildOutput)
+	assert.Equal(t, input.FunctionID+"\n", readOutput(t, input, "marker.txt"))
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"postBuild": `echo failing >&2; exit 3`,
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "exit status 3")
+	assert.ErrorContains(t, err, "failing")
+
+	input = buildInput(t, cfgPath, "src/broken.handler", map[string]interface{}{
+		"postBuild": `touch marker.txt`,
+	})
+	require.NoError(t, os.RemoveAll(input.Out()))
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Errors)
+	assert.NoFileExists(t, filepath.Join(input.Out(), "marker.txt"))
+}
+
+func TestBuildCopy(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":          `export const handler = () => "ok";`,
+		"prisma/schema.prisma":  `datasource db {}`,
+		"config/app.json":       `{"name":"app"}`,
+		"config/features.json":  `{"beta":true}`,
+		"config/README.md":      `not copied`,
+		"templates/email/a.txt": `hello`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"copy": []map[string]string{
+			{"from": "prisma/schema.prisma"},
+			{"from": "config/*.json", "to": "settings"},
+			{"from": "templates", "to": "views"},
+		},
+	})
+	_, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, `datasource db {}`, readOutput(t, input, "prisma/schema.prisma"))
+	assert.Equal(t, `{"name":"app"}`, readOutput(t, input, "settings/app.json"))
+	assert.Equal(t, `{"beta":true}`, readOutput(t, input, "settings/features.json"))
+	assert.NoFileExists(t, filepath.Join(input.Out(), "settings/README.md"))
+	assert.Equal(t, `hello`, readOutput(t, input, "views/email/a.txt"))
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"copy": []map[string]string{{"from": "missing.json"}},
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "copy source not found")
+
+	for _, to := range []string{"../escaped.json", "settings/../../escaped.json", filepath.Join(t.TempDir(), "escaped.json")} {
+		input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+			"copy": []map[string]string{{"from": "config/app.json", "to": to}},
+		})
+		_, err = New().Build(context.Background(), input)
+		assert.ErrorContains(t, err, "must be a path inside the output directory", to)
+		assert.NoFileExists(t, filepath.Join(filepath.Dir(input.Out()), "escaped.json"), to)
+	}
+}
+
+func TestCollectionCopyFiles(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":          `export const handler = () => "ok";`,
+		"schema.json":           `{"version":1}`,
+		"templates/email/a.txt": `hello`,
+		"routes/[id]/page.js":   `literal`,
+		"routes/i/page.js":      `glob`,
+	})
+	root := filepath.Dir(cfgPath)
+	collection := runtime.NewCollection(cfgPath, New())
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.CopyFiles = []runtime.CopyFile{
+		{From: filepath.Join(root, "schema.json"), To: "schema.json"},
+		{From: filepath.Join(root, "templates"), To: "views"},
+		{From: filepath.Join(root, "routes/[id]"), To: "routes/[id]"},
+	}
+	_, err := collection.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, `{"version":1}`, readOutput(t, input, "schema.json"))
+	assert.Equal(t, `hello`, readOutput(t, input, "views/email/a.txt"))
+	assert.Equal(t, `literal`, readOutput(t, input, "routes/[id]/page.js"))
+	assert.NoDirExists(t, filepath.Join(input.Out(), "routes/[id]/i"))
+
+	input.Dev = true
+	_, err = collection.Build(context.Background(), input)
+	require.NoError(t, err)
+	link, err := os.Readlink(filepath.Join(input.Out(), "views"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "templates"), link)
+
+	input.Dev = false
+	input.CopyFiles = []runtime.CopyFile{{From: filepath.Join(root, "schema.json"), To: "../escaped.json"}}
+	_, err = collection.Build(context.Background(), input)
+	assert.ErrorContains(t, err, "must be a path inside the output directory")
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(input.Out()), "escaped.json"))
+}
+
+func TestBuildIncremental(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `import { value } from "./values"; export const handler = () => [value, "one"];`,
+		"src/values.ts": `export const value = "value";`,
+	})
+	r := New()
+	contexts := 0
+	rebuilds := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		buildContext, err := esbuild.Context(options)
+		if err != nil {
+			return nil, err
+		}
+		contexts++
+		return countingContext{buildContext, &rebuilds}, nil
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	first, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.False(t, first.Incremental)
+	assert.Greater(t, first.Duration, time.Duration(0))
+
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cfgPath), "src/index.ts"), []byte(`import { value } from "./values"; export const handler = () => [value, "two"];`), 0644))
+	second, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.True(t, second.Incremental)
+	assert.Equal(t, 1, contexts)
+	assert.Equal(t, 2, rebuilds)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `"two"`)
+
+	cached, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.True(t, cached.Incremental)
+	assert.Zero(t, cached.Duration)
+	assert.Equal(t, 1, contexts)
+	assert.Equal(t, 2, rebuilds)
+}
+
+func TestBuildPlatformNeutral(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { target } from "lib"; import sharp from "sharp"; export const handler = () => [target, sharp];`,
+		"node_modules/lib/package.json": `{"name":"lib","exports":"./portable.js","main":"./node.js","module":"./module.js"}`,
+		"node_modules/lib/portable.js":  `export const target = "from-exports";`,
+		"node_modules/lib/node.js":      `export const target = "from-main";`,
+		"node_modules/lib/module.js":    `export const target = "from-module";`,
+		"node_modules/sharp/index.js":   `export default "sharp-bundled";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"platform": "neutral",
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, "from-exports")
+	assert.Contains(t, output, "sharp-bundled")
+	assert.Contains(t, output, "globalThis.$SST_LINKS")
+	for _, shim := range []string{"topLevelCreateRequire", "__dirname", "__filename", "import.meta.url"} {
+		assert.NotContains(t, output, shim)
+	}
+
+	// Without main fields a package that only has main does not resolve.
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cfgPath), "node_modules/lib/package.json"), []byte(`{"name":"lib","main":"./node.js"}`), 0644))
+	input.FunctionID = "neutral-main"
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Errors)
+}
+
+func TestWarm(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/a.ts": `export const handler = () => "a";`,
+		"src/b.ts": `export const handler = () => "b";`,
+		"src/c.ts": `export const handler = () => "c";`,
+	})
+	r := New()
+	rebuilds := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		buildContext, err := esbuild.Context(options)
+		if err != nil {
+			return nil, err
+		}
+		return countingContext{buildContext, &rebuilds}, nil
+	}
+	inputs := []*runtime.BuildInput{}
+	for _, name := range []string{"a", "b", "c"} {
+		input := buildInput(t, cfgPath, "src/"+name+".handler", map[string]interface{}{})
+		input.FunctionID = name
+		inputs = append(inputs, input)
+	}
+	missing := buildInput(t, cfgPath, "src/missing.handler", map[string]interface{}{})
+	missing.FunctionID = "missing"
+
+	err := r.Warm(context.Background(), append(inputs, missing))
+	assert.ErrorContains(t, err, "failed to warm function missing")
+	for _, input := range inputs {
+		assert.Contains(t, r.contexts, input.FunctionID)
+	}
+	assert.NotContains(t, r.contexts, "missing")
+	assert.Equal(t, 0, rebuilds)
+
+	warmed := r.contexts["a"]
+	result, err := r.Build(context.Background(), inputs[0])
+	require.NoError(t, err)
+	assert.True(t, result.Incremental)
+	assert.Equal(t, warmed, r.contexts["a"])
+	assert.Equal(t, 1, rebuilds)
+}
+
+func TestBuildOutputName(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/api/users.ts":  `export const handler = async () => (await import("./shared")).shared;`,
+		"src/api/shared.ts": `export const shared = "shared";`,
+	})
+	input := buildInput(t, cfgPath, "src/api/users.handler", map[string]interface{}{
+		"outputName": "index",
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, "index.handler", result.Handler)
+	assert.FileExists(t, filepath.Join(input.Out(), "index.mjs"))
+	assert.NoFileExists(t, filepath.Join(input.Out(), "src/api/users.mjs"))
+
+	input = buildInput(t, cfgPath, "src/api/users.handler", map[string]interface{}{
+		"outputName": "index",
+		"splitting":  true,
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, "index.handler", result.Handler)
+	assert.FileExists(t, filepath.Join(input.Out(), "index.mjs"))
+
+	for _, name := range []string{"../index", "/tmp/index", "a/../../index", "."} {
+		input = buildInput(t, cfgPath, "src/api/users.handler", map[string]interface{}{
+			"outputName": name,
+		})
+		_, err = New().Build(context.Background(), input)
+		assert.ErrorContains(t, err, "invalid outputName", name)
+	}
+}
+
+func TestBuildSplittingFormat(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = async () => (await import("./shared")).shared;`,
+		"src/shared.ts": `export const shared = "shared";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"format":    "cjs",
+		"splitting": true,
+	})
+	_, err := New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "code splitting requires ESM format")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"format":    "esm",
+		"splitting": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+}
+
+func TestBuildPlugins(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	script, err := os.ReadFile("../../../platform/functions/nodejs-runtime/plugin.mjs")
+	require.NoError(t, err)
+	cfgPath := setupProject(t, map[string]string{
+		".sst/platform/functions/nodejs-runtime/plugin.mjs": string(script),
+		"src/index.ts": `import { schema } from "virtual:schema"; export const handler = () => schema;`,
+		"plugins/schema.mjs": `export default (options) => [{
+			name: "schema",
+			setup(build) {
+				build.onResolve({ filter: "^virtual:schema$" }, (args) => ({ path: args.path, namespace: "schema" }));
+				build.onLoad({ filter: ".*" }, (args) => args.namespace === "schema"
+					? { contents: "export const schema = " + JSON.stringify(options.schema), loader: "js" }
+					: undefined);
+			},
+		}];`,
+		"plugins/banner.mjs": `export default [{
+			name: "banner",
+			setup(build) {
+				build.onResolve({ filter: "^virtual:banner$" }, (args) => ({ path: args.path, namespace: "banner" }));
+			},
+		}];`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"plugins": []interface{}{
+			"plugins/banner.mjs",
+			map[string]interface{}{
+				"path":    "plugins/schema.mjs",
+				"options": map[string]string{"schema": "prisma/schema.prisma"},
+			},
+		},
+	})
+	r := New()
+	t.Cleanup(func() { r.contexts[input.FunctionID].Dispose() })
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `"prisma/schema.prisma"`)
+}
+
+func TestPluginConfigs(t *testing.T) {
+	var properties NodeProperties
+	require.NoError(t, json.Unmarshal([]byte(`{"plugins":"plugins.mjs"}`), &properties))
+	assert.Equal(t, PluginConfigs{{Path: "plugins.mjs"}}, properties.Plugins)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"plugins":["a.mjs",{"path":"b.mjs","options":{"debug":true}}]}`), &properties))
+	assert.Equal(t, PluginConfigs{
+		{Path: "a.mjs"},
+		{Path: "b.mjs", Options: map[string]interface{}{"debug": true}},
+	}, properties.Plugins)
+}
+
+func TestBuildTimeout(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	r := New(WithBuildOptions(func(options *esbuild.BuildOptions) {
+		options.Plugins = append(options.Plugins, esbuild.Plugin{
+			Name: "stuck",
+			Setup: func(build esbuild.PluginBuild) {
+				build.OnLoad(esbuild.OnLoadOptions{Filter: `\.ts$`}, func(args esbuild.OnLoadArgs) (esbuild.OnLoadResult, error) {
+					<-release
+					return esbuild.OnLoadResult{}, nil
+				})
+			},
+		})
+	}))
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"timeout": "100ms",
+	})
+	start := time.Now()
+	_, err := r.Build(context.Background(), input)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.NotContains(t, r.contexts, input.FunctionID)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"timeout": "soon",
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, `invalid timeout "soon"`)
+}
+
+func TestBuildVirtualEntry(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/user.ts": `export const greet = (name: string) => "hello " + name;`,
+	})
+	input := buildInput(t, cfgPath, "src/wrapper.handler", map[string]interface{}{
+		"virtualEntry": map[string]string{
+			"filename": "src/wrapper.ts",
+			"contents": `import { greet } from "./user"; export const handler = (event: { name: string }) => greet(event.name);`,
+		},
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, "src/wrapper.handler", result.Handler)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(cfgPath), "src/wrapper.ts"))
+	assert.Contains(t, readOutput(t, input, "src/wrapper.mjs"), `"hello "`)
+
+	input = buildInput(t, cfgPath, "src/wrapper.handler", map[string]interface{}{
+		"virtualEntry": map[string]string{
+			"filename": "src/wrapper.ts",
+			"contents": `export const main = () => "ok";`,
+		},
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(result.Errors, "\n"), `handler export "handler" not found`)
+}
+
+func TestBuildInputs(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import { a } from "./lib/a"; import { b } from "./lib/b"; export const handler = () => a + b;`,
+		"src/lib/a.ts": `export const a = "a";`,
+		"src/lib/b.ts": `export const b = "b";`,
+		"src/lib/c.ts": `export const c = "c";`,
+	})
+	root := filepath.Dir(cfgPath)
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "src/index.ts"),
+		filepath.Join(root, "src/lib/a.ts"),
+		filepath.Join(root, "src/lib/b.ts"),
+	}, result.Inputs)
+	for _, file := range result.Inputs {
+		assert.True(t, filepath.IsAbs(file), file)
+	}
+}
+
+func TestBuildAllowOverwrite(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		".sst/artifacts/fn/src/index.mjs": `export const shared = "generated";`,
+		"src/index.ts":                    `import { shared } from "../.sst/artifacts/fn/src/index.mjs"; export const handler = () => shared;`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(result.Errors, "\n"), "Refusing to overwrite input file")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"allowOverwrite": true,
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), `"generated"`)
+}
+
+func TestBuildPluginErrors(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	r := New(WithBuildOptions(func(options *esbuild.BuildOptions) {
+		options.Plugins = append(options.Plugins, esbuild.Plugin{
+			Name: "broken",
+			Setup: func(build esbuild.PluginBuild) {
+				build.OnLoad(esbuild.OnLoadOptions{Filter: `\.ts$`}, func(args esbuild.OnLoadArgs) (esbuild.OnLoadResult, error) {
+					return esbuild.OnLoadResult{}, fmt.Errorf("schema missing")
+				})
+			},
+		})
+	}))
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0], "[plugin broken]")
+	assert.Contains(t, result.Errors[0], "schema missing")
+	require.Len(t, result.DetailedErrors, 1)
+	assert.Equal(t, "broken", result.DetailedErrors[0].Plugin)
+}
+
+func TestBuildNodePluginErrors(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	script, err := os.ReadFile("../../../platform/functions/nodejs-runtime/plugin.mjs")
+	require.NoError(t, err)
+	cfgPath := setupProject(t, map[string]string{
+		".sst/platform/functions/nodejs-runtime/plugin.mjs": string(script),
+		"src/index.ts": `export const handler = () => "ok";`,
+		"plugins/prisma.mjs": `export default [{
+			name: "prisma",
+			setup(build) {
+				build.onLoad({ filter: "\\.ts$" }, () => { throw new Error("schema missing"); });
+			},
+		}];`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"plugins": "plugins/prisma.mjs",
+	})
+	r := New()
+	t.Cleanup(func() { r.contexts[input.FunctionID].Dispose() })
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Errors)
+	assert.Contains(t, result.Errors[0], "[plugin prisma]")
+	assert.Contains(t, result.Errors[0], "schema missing")
+}
+
+func TestBuildNodeBuiltins(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import { randomUUID } from "node:crypto"; import { readFile } from "fs/promises"; export const handler = () => [randomUUID(), readFile];`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `from "node:crypto"`)
+	assert.Contains(t, output, `from "fs/promises"`)
+	assert.Empty(t, result.Externals)
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"platform": "browser",
+	})
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	errors := strings.Join(result.Errors, "\n")
+	assert.Contains(t, errors, `Could not resolve "node:crypto", node builtins are not available on the browser platform`)
+	assert.Contains(t, errors, `alias: { "fs/promises": "<polyfill>" }`)
+}
+
+func TestIsNodeBuiltin(t *testing.T) {
+	for _, path := range []string{"fs", "node:crypto", "fs/promises", "node:stream/web"} {
+		assert.True(t, isNodeBuiltin(path), path)
+	}
+	for _, path := range []string{"lodash", "node-fetch", "@aws-sdk/client-s3", "./fs"} {
+		assert.False(t, isNodeBuiltin(path), path)
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/a.ts": `export const handler = () => "a";`,
+		"src/b.ts": `export const handler = () => "b";`,
+	})
+	r := New(WithProfile(true))
+	a := buildInput(t, cfgPath, "src/a.handler", map[string]interface{}{})
+	a.FunctionID = "a"
+	b := buildInput(t, cfgPath, "src/b.handler", map[string]interface{}{})
+	b.FunctionID = "b"
+
+	_, err := r.Build(context.Background(), a)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cfgPath), "src/a.ts"), []byte(`export const handler = () => "changed";`), 0644))
+	result, err := r.Build(context.Background(), a)
+	require.NoError(t, err)
+	_, err = r.Build(context.Background(), b)
+	require.NoError(t, err)
+
+	stats := map[string]BuildStat{}
+	for _, stat := range r.BuildStats() {
+		stats[stat.FunctionID] = stat
+	}
+	require.Len(t, stats, 2)
+	assert.Equal(t, 2, stats["a"].Count)
+	assert.Equal(t, 1, stats["b"].Count)
+	assert.Equal(t, result.Size, stats["a"].Size)
+	assert.Equal(t, stats["a"].Total/2, stats["a"].Average)
+	assert.GreaterOrEqual(t, stats["a"].Total, stats["a"].Max)
+
+	r = New(WithProfile(false))
+	_, err = r.Build(context.Background(), b)
+	require.NoError(t, err)
+	assert.Empty(t, r.BuildStats())
+}
+
+func TestBuildIIFE(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"format":     "iife",
+		"globalName": "scripts",
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, "src/index.handler", result.Handler)
+	output := readOutput(t, input, "src/index.js")
+	assert.Contains(t, output, "var scripts = (() => {")
+	assert.Contains(t, output, "globalThis.$SST_LINKS")
+	assert.NotContains(t, output, "topLevelCreateRequire")
+	assert.NotContains(t, output, "export {")
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"format":    "iife",
+		"splitting": true,
+	})
+	_, err = New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "code splitting requires ESM format")
+}
+
+func TestBuildBrowserField(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import { target } from "lib"; export const handler = () => [target, process.env.NODE_ENV];`,
+		"node_modules/lib/package.json": `{"name":"lib","main":"./node.js","browser":{"./node.js":"./browser.js"}}`,
+		"node_modules/lib/node.js":      `export const target = "from-node";`,
+		"node_modules/lib/browser.js":   `export const target = "from-browser";`,
+	})
+	tests := []struct {
+		name     string
+		props    map[string]interface{}
+		expected string
+	}{
+		{"browser", map[string]interface{}{"platform": "browser"}, "from-browser"},
+		{"browser-ignored", map[string]interface{}{"platform": "browser", "browserField": false}, "from-node"},
+		{"neutral", map[string]interface{}{"platform": "neutral", "browserField": true}, "from-browser"},
+	}
+	for _, test := range tests {
+		input := buildInput(t, cfgPath, "src/index.handler", test.props)
+		input.FunctionID = test.name
+		result, err := New().Build(context.Background(), input)
+		require.NoError(t, err, test.name)
+		require.Empty(t, result.Errors, test.name)
+		output := readOutput(t, input, "src/index.mjs")
+		assert.Contains(t, output, test.expected, test.name)
+		assert.Contains(t, output, `"development"`, test.name)
+	}
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{"browserField": true})
+	_, err := New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, "browserField requires the browser or neutral platform")
+}
+
+func TestBuildAll(t *testing.T) {
+	files := map[string]string{}
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	for _, name := range names {
+		files["src/"+name+".ts"] = `export const handler = () => "` + name + `";`
+	}
+	cfgPath := setupProject(t, files)
+	inputs := []*runtime.BuildInput{}
+	for _, name := range names {
+		input := buildInput(t, cfgPath, "src/"+name+".handler", map[string]interface{}{})
+		input.FunctionID = name
+		inputs = append(inputs, input)
+	}
+	missing := buildInput(t, cfgPath, "src/missing.handler", map[string]interface{}{})
+	missing.FunctionID = "missing"
+
+	outputs, err := New(WithBuildConcurrency(2)).BuildAll(context.Background(), append(inputs, missing))
+	require.NoError(t, err)
+	require.Len(t, outputs, len(names)+1)
+	for i, name := range names {
+		assert.Equal(t, "src/"+name+".handler", outputs[i].Handler)
+		assert.Empty(t, outputs[i].Errors, name)
+		assert.Contains(t, readOutput(t, inputs[i], "src/"+name+".mjs"), `"`+name+`"`)
+	}
+	assert.Equal(t, "src/missing.handler", outputs[len(names)].Handler)
+	assert.NotEmpty(t, outputs[len(names)].Errors)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	outputs, err = New().BuildAll(ctx, inputs)
+	assert.ErrorIs(t, err, context.Canceled)
+	for _, output := range outputs {
+		assert.Equal(t, []string{context.Canceled.Error()}, output.Errors)
+	}
+}
+
+func TestBuildEntry(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, filepath.Join(filepath.Dir(cfgPath), "src/index.ts"), result.Entry)
+	assert.True(t, filepath.IsAbs(result.Entry))
+}
+
+func TestBuildTypeModule(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"package.json": `{"type":"commonjs"}`,
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	tests := []struct {
+		name   string
+		props  map[string]interface{}
+		output string
+		marker bool
+	}{
+		{"esm-js", map[string]interface{}{"typeModule": true, "outExtension": ".js"}, "src/index.js", true},
+		{"esm-mjs", map[string]interface{}{"typeModule": true}, "src/index.mjs", false},
+		{"cjs", map[string]interface{}{"typeModule": true, "format": "cjs", "outExtension": ".js"}, "src/index.js", false},
+		{"off", map[string]interface{}{"outExtension": ".js"}, "src/index.js", false},
+	}
+	for _, test := range tests {
+		input := buildInput(t, cfgPath, "src/index.handler", test.props)
+		input.FunctionID = test.name
+		result, err := New().Build(context.Background(), input)
+		require.NoError(t, err, test.name)
+		require.Empty(t, result.Errors, test.name)
+		readOutput(t, input, test.output)
+		_, err = os.Stat(filepath.Join(input.Out(), "package.json"))
+		if !test.marker {
+			assert.True(t, os.IsNotExist(err), test.name)
+			continue
+		}
+		assert.JSONEq(t, `{"type":"module"}`, readOutput(t, input, "package.json"))
+		if _, err := exec.LookPath("node"); err == nil {
+			out, err := exec.Command("node", "--input-type=module", "-e",
+				`const mod = await import(process.argv[1]); console.log(mod.handler());`,
+				filepath.Join(input.Out(), test.output)).CombinedOutput()
+			require.NoError(t, err, string(out))
+			assert.Equal(t, "ok\n", string(out))
+		}
+	}
+
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{"outExtension": "js"})
+	_, err := New().Build(context.Background(), input)
+	assert.ErrorContains(t, err, `invalid outExtension "js"`)
+}
+
+func TestBuildEvents(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `export const handler = () => "ok";`,
+		"src/broken.ts": `export const handler = () => {`,
+	})
+	var events []BuildEvent
+	r := New(WithOnEvent(func(event BuildEvent) {
+		events = append(events, event)
+	}))
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, BuildStarted, events[0].Type)
+	assert.Equal(t, BuildFinished, events[1].Type)
+	for _, event := range events {
+		assert.Equal(t, "fn", event.FunctionID)
+	}
+	assert.False(t, events[1].Time.Before(events[0].Time))
+	assert.Equal(t, events[1].Time.Sub(events[0].Time), events[1].Duration)
+	assert.Zero(t, events[1].Errors)
+
+	events = nil
+	input = buildInput(t, cfgPath, "src/broken.handler", map[string]interface{}{})
+	input.FunctionID = "broken"
+	_, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, BuildStarted, events[0].Type)
+	assert.Equal(t, BuildFailed, events[1].Type)
+	assert.Equal(t, 1, events[1].Errors)
+}
+
+func TestBuildScratchDir(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":                  `import lib from "lib"; export const handler = () => lib;`,
+		"node_modules/lib/index.js":     `export default "lib";`,
+		"node_modules/lib/package.json": `{"name":"lib"}`,
+	})
+	scratch := filepath.Join(t.TempDir(), "scratch")
+	r := New(WithScratchDir(scratch), WithCommand("sh", "-c", `echo "$NODE_PATH"`, "sh"))
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.Dev = true
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+
+	written := []string{}
+	require.NoError(t, filepath.Walk(input.Out(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(input.Out(), path)
+		written = append(written, filepath.ToSlash(rel))
+		return err
+	}))
+	assert.ElementsMatch(t, []string{"src/index.mjs", "src/index.mjs.map"}, written)
+	link := filepath.Join(scratch, "fn", "node_modules")
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(cfgPath), "node_modules"), target)
+
+	worker, err := r.Run(context.Background(), &runtime.RunInput{
+		CfgPath:    cfgPath,
+		FunctionID: "fn",
+		WorkerID:   "worker",
+		Build:      result,
+	})
+	require.NoError(t, err)
+	logs, err := io.ReadAll(worker.Logs())
+	require.NoError(t, err)
+	assert.Equal(t, link+"\n", string(logs))
+
+	blocked := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(blocked, []byte{}, 0644))
+	_, err = New(WithScratchDir(blocked)).Build(context.Background(), input)
+	assert.ErrorContains(t, err, "scratch directory "+blocked+" is not writable")
+}
+
+func TestBuildDefineFile(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"define.json":  `{"STAGE":"one","COUNT":3,"FLAGS":{"debug":true},"OVERRIDDEN":"file"}`,
+		"src/index.ts": `export const handler = () => [STAGE, COUNT, FLAGS.debug, OVERRIDDEN];`,
+	})
+	defineFile := filepath.Join(filepath.Dir(cfgPath), "define.json")
+	r := New()
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"defineFile": "define.json",
+		"define":     map[string]string{"OVERRIDDEN": `"inline"`},
+	})
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `"one"`)
+	assert.Contains(t, output, `3`)
+	assert.Contains(t, output, `debug: true`)
+	assert.Contains(t, output, `"inline"`)
+	assert.NotContains(t, output, `"file"`)
+
+	assert.True(t, r.ShouldRebuild("fn", defineFile))
+	assert.False(t, r.ShouldRebuild("fn", filepath.Join(filepath.Dir(cfgPath), "other.json")))
+
+	require.NoError(t, os.WriteFile(defineFile, []byte(`{"STAGE":"two","COUNT":3,"FLAGS":{},"OVERRIDDEN":"file"}`), 0644))
+	result, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	output = readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `"two"`)
+	assert.NotContains(t, output, `"one"`)
+}
+
+func TestBuildCircularImports(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import { a } from "./a"; import { self } from "./self"; export const handler = () => [a, self()];`,
+		"src/a.ts":     `import { b } from "./b"; export const a = "a"; export const useB = () => b;`,
+		"src/b.ts":     `import { a } from "./a"; export const b = "b"; export const useA = () => a;`,
+		"src/self.ts":  `import * as me from "./self"; export const self = () => Object.keys(me);`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"detectCircularImports": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Contains(t, result.Warnings, "circular import between src/a.ts, src/b.ts")
+	assert.Contains(t, result.Warnings, "circular import between src/self.ts")
+	for _, warning := range result.Warnings {
+		assert.NotContains(t, warning, "src/index.ts")
+	}
+
+	input = buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	input.FunctionID = "off"
+	result, err = New().Build(context.Background(), input)
+	require.NoError(t, err)
+	for _, warning := range result.Warnings {
+		assert.NotContains(t, warning, "circular import")
+	}
+}
+
+func TestBuildMissingLoader(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": "import data from \"./data.bin\";\nexport const handler = () => data;",
+		"src/data.bin": "binary",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0], `No loader is configured for ".bin" files: src/data.bin, add loader: { ".bin": "file" } to your function's loader config`)
+	assert.True(t, strings.HasSuffix(result.Errors[0], " src/index.ts:1:17"), result.Errors[0])
+	require.Len(t, result.DetailedErrors, 1)
+	assert.Equal(t, "src/index.ts", result.DetailedErrors[0].File)
+	assert.Equal(t, 1, result.DetailedErrors[0].Line)
+	assert.Equal(t, 17, result.DetailedErrors[0].Column)
+	assert.Contains(t, result.DetailedErrors[0].Text, `add loader: { ".bin": "file" }`)
+}
+
+func TestBuildWarnings(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": "export const handler = (x: number) =>\n  x === -0;",
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"minfy": true,
+	})
+	result, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Len(t, result.Warnings, 2)
+	require.Len(t, result.DetailedWarnings, 2)
+	assert.Equal(t, `ignoring function properties: unknown field "minfy"`, result.Warnings[0])
+	assert.Equal(t, runtime.BuildError{Text: result.Warnings[0]}, result.DetailedWarnings[0])
+	assert.Contains(t, result.Warnings[1], "-0")
+	assert.True(t, strings.HasSuffix(result.Warnings[1], " src/index.ts:2:8"), result.Warnings[1])
+	assert.Equal(t, "src/index.ts", result.DetailedWarnings[1].File)
+	assert.Equal(t, 2, result.DetailedWarnings[1].Line)
+	assert.True(t, strings.HasPrefix(result.Warnings[1], result.DetailedWarnings[1].Text))
+}
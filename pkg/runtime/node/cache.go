@@ -0,0 +1,214 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sst/ion/internal/fs"
+	"github.com/sst/ion/pkg/js"
+	"github.com/sst/ion/pkg/project/path"
+	"github.com/sst/ion/pkg/runtime"
+)
+
+type cachedBuild struct {
+	key    string
+	output *runtime.BuildOutput
+}
+
+// buildKey hashes what a build depends on, the build input, every file
+// bundled by the previous build and the files that shape the output without
+// being bundled, see unbundledDependencies. Dependencies in node_modules are
+// compared by size and modification time instead of contents to keep this
+// cheap.
+func buildKey(input *runtime.BuildInput, metafile string) (string, error) {
+	var parsed js.Metafile
+	if err := json.Unmarshal([]byte(metafile), &parsed); err != nil || parsed.Inputs == nil {
+		return "", fmt.Errorf("invalid metafile")
+	}
+	root, err := filepath.Abs(path.ResolveRootDir(input.CfgPath))
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if err := json.NewEncoder(hash).Encode(input); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(parsed.Inputs))
+	for key := range parsed.Inputs {
+		// Skip esbuild's virtual modules like <runtime>.
+		if !strings.HasPrefix(key, "<") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		file := filepath.Join(root, key)
+		if strings.Contains(key, "node_modules/") {
+			info, err := os.Stat(file)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(hash, "%v\x00%v\x00%v\x00", key, info.Size(), info.ModTime().UnixNano())
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%v\x00%v\x00", key, len(data))
+		hash.Write(data)
+	}
+	files, err := unbundledDependencies(input, root, keys)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(hash, "%v\x00missing\x00", file)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%v\x00%v\x00", file, len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// unbundledDependencies lists the files that affect a build without showing
+// up in its metafile: the define file, the tsconfig esbuild picks for each
+// bundled file, copied files, and the package.json install versions are read
+// from. Files that do not exist are listed too, creating them changes the
+// build.
+func unbundledDependencies(input *runtime.BuildInput, root string, inputs []string) ([]string, error) {
+	properties, _, err := parseProperties(input.Properties)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	if properties.DefineFile != "" {
+		files = append(files, filepath.Join(root, properties.DefineFile))
+	}
+	if properties.Tsconfig != "" {
+		files = append(files, filepath.Join(root, properties.Tsconfig))
+	} else {
+		dirs := map[string]bool{}
+		for _, key := range inputs {
+			if strings.Contains(key, "node_modules/") || strings.Contains(key, ":") {
+				continue
+			}
+			for dir := filepath.Dir(filepath.Join(root, key)); !dirs[dir]; dir = filepath.Dir(dir) {
+				dirs[dir] = true
+				tsconfig := filepath.Join(dir, "tsconfig.json")
+				if _, err := os.Stat(tsconfig); err == nil {
+					files = append(files, tsconfig)
+					break
+				}
+				if dir == root || dir == filepath.Dir(dir) {
+					break
+				}
+			}
+		}
+	}
+	copied, err := copySources(root, properties.Copy)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, copied...)
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	copied, err = copySources(cwd, input.CopyFiles)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, copied...)
+	if !input.Dev && !properties.SkipInstall {
+		if pkg, err := fs.FindUp(input.Out(), "package.json"); err == nil {
+			files = append(files, pkg)
+		}
+	}
+	sort.Strings(files)
+	return slices.Compact(files), nil
+}
+
+// Cached implements runtime.CachedRuntime, see cachedBuild.
+func (r *Runtime) Cached(input *runtime.BuildInput) (*runtime.BuildOutput, bool) {
+	return r.cachedBuild(input)
+}
+
+// cachedBuild returns the output of the last build when none of its inputs
+// changed and its output files are still in place.
+func (r *Runtime) cachedBuild(input *runtime.BuildInput) (*runtime.BuildOutput, bool) {
+	r.lock.RLock()
+	cached, ok := r.builds[input.FunctionID]
+	metafile := r.metafiles[input.FunctionID]
+	r.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	key, err := buildKey(input, metafile)
+	if err != nil || key != cached.key {
+		return nil, false
+	}
+	for file := range cached.output.Sizes {
+		if _, err := os.Stat(filepath.Join(input.Out(), file)); err != nil {
+			return nil, false
+		}
+	}
+	output := *cached.output
+	output.Incremental = true
+	output.Duration = 0
+	return &output, true
+}
+
+// storeBuild caches a successful build output for cachedBuild.
+func (r *Runtime) storeBuild(input *runtime.BuildInput, output *runtime.BuildOutput) {
+	r.lock.RLock()
+	metafile := r.metafiles[input.FunctionID]
+	r.lock.RUnlock()
+	key, err := buildKey(input, metafile)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil {
+		delete(r.builds, input.FunctionID)
+		return
+	}
+	cached := *output
+	r.builds[input.FunctionID] = cachedBuild{key: key, output: &cached}
+}
+
+// copySources lists the files runtime.CopyFiles copies for the entries,
+// walking matched directories.
+func copySources(root string, entries []runtime.CopyFile) ([]string, error) {
+	files := []string{}
+	for _, entry := range entries {
+		matches, _, err := entry.Matches(root)
+		if err != nil {
+			// The build reports the missing source.
+			continue
+		}
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					files = append(files, path)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"time"
+
+	"github.com/sst/ion/pkg/runtime"
+)
+
+type BuildEventType string
+
+const (
+	BuildStarted  BuildEventType = "started"
+	BuildFinished BuildEventType = "finished"
+	BuildFailed   BuildEventType = "failed"
+)
+
+// BuildEvent reports the progress of a Build to the handler set with
+// WithOnEvent. Started events only carry the function and time, the others
+// also carry how long the build took and its error and warning counts. A
+// build fails when it returns an error, Err, or has bundler errors.
+type BuildEvent struct {
+	Type       BuildEventType
+	FunctionID string
+	Time       time.Time
+	Duration   time.Duration
+	Errors     int
+	Warnings   int
+	Err        error
+}
+
+// emit passes the event to the handler, if any. Events from concurrent
+// builds are delivered one at a time.
+func (r *Runtime) emit(event BuildEvent) {
+	if r.options.OnEvent == nil {
+		return
+	}
+	r.eventLock.Lock()
+	defer r.eventLock.Unlock()
+	r.options.OnEvent(event)
+}
+
+// observeBuild emits the started event and returns a function that emits the
+// outcome of the build.
+func (r *Runtime) observeBuild(functionID string) func(output *runtime.BuildOutput, err error) {
+	started := time.Now()
+	r.emit(BuildEvent{Type: BuildStarted, FunctionID: functionID, Time: started})
+	return func(output *runtime.BuildOutput, err error) {
+		event := BuildEvent{
+			Type:       BuildFinished,
+			FunctionID: functionID,
+			Time:       time.Now(),
+			Err:        err,
+		}
+		event.Duration = event.Time.Sub(started)
+		if output != nil {
+			event.Errors = len(output.Errors)
+			event.Warnings = len(output.Warnings)
+		}
+		if err != nil || event.Errors > 0 {
+			event.Type = BuildFailed
+		}
+		r.emit(event)
+	}
+}
@@ -0,0 +1,20 @@
+package node
+
+import (
+	"os"
+)
+
+// ensureLink links target at link, replacing an existing link that points
+// somewhere else.
+func ensureLink(target string, link string) error {
+	existing, err := os.Readlink(link)
+	if err == nil && existing == target {
+		return nil
+	}
+	if err == nil || !os.IsNotExist(err) {
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	}
+	return linkDir(target, link)
+}
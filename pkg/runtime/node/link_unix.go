@@ -0,0 +1,9 @@
+//go:build !windows
+
+package node
+
+import "os"
+
+func linkDir(target string, link string) error {
+	return os.Symlink(target, link)
+}
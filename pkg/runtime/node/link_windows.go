@@ -0,0 +1,25 @@
+package node
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+
+	"github.com/sst/ion/pkg/runtime"
+)
+
+// Symlinking directories on Windows requires Developer Mode or admin
+// privileges, so fall back to a directory junction and then to a copy.
+func linkDir(target string, link string) error {
+	err := os.Symlink(target, link)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	slog.Info("symlink failed, trying junction", "target", target, "link", link, "err", err)
+	err = exec.Command("cmd", "/c", "mklink", "/J", link, target).Run()
+	if err == nil {
+		return nil
+	}
+	slog.Info("junction failed, copying", "target", target, "link", link, "err", err)
+	return runtime.CopyDir(target, link)
+}
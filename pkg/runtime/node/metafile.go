@@ -0,0 +1,201 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sst/ion/pkg/js"
+)
+
+// findCycles returns every group of inputs in the metafile import graph that
+// import each other, using Tarjan's strongly connected components algorithm.
+func findCycles(metafile js.Metafile) [][]string {
+	keys := make([]string, 0, len(metafile.Inputs))
+	for key := range metafile.Inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	cycles := [][]string{}
+
+	var visit func(node string)
+	visit = func(node string) {
+		index[node] = len(index)
+		lowlink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+
+		selfLoop := false
+		for _, imp := range metafile.Inputs[node].Imports {
+			if _, ok := metafile.Inputs[imp.Path]; !ok {
+				continue
+			}
+			if imp.Path == node {
+				selfLoop = true
+			}
+			if _, ok := index[imp.Path]; !ok {
+				visit(imp.Path)
+				lowlink[node] = min(lowlink[node], lowlink[imp.Path])
+			} else if onStack[imp.Path] {
+				lowlink[node] = min(lowlink[node], index[imp.Path])
+			}
+		}
+
+		if lowlink[node] != index[node] {
+			return
+		}
+		component := []string{}
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			component = append(component, last)
+			if last == node {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, key := range keys {
+		if _, ok := index[key]; !ok {
+			visit(key)
+		}
+	}
+	return cycles
+}
+
+// outputSizes returns the size of each output file keyed by its path relative
+// to out, along with the total.
+func outputSizes(metafile js.Metafile, root string, out string) (map[string]int64, int64) {
+	sizes := map[string]int64{}
+	total := int64(0)
+	for key, output := range metafile.Outputs {
+		rel, err := filepath.Rel(out, filepath.Join(root, key))
+		if err != nil {
+			rel = key
+		}
+		sizes[rel] = int64(output.Bytes)
+		total += int64(output.Bytes)
+	}
+	return sizes, total
+}
+
+// hashOutputs hashes the given output files relative to out in sorted order so
+// the result only changes when the bundle does. Sourcemaps are skipped since
+// they embed volatile paths.
+func hashOutputs(out string, files []string) (string, error) {
+	sorted := []string{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".map") {
+			sorted = append(sorted, file)
+		}
+	}
+	if len(sorted) == 0 {
+		return "", nil
+	}
+	sort.Strings(sorted)
+	hash := sha256.New()
+	for _, file := range sorted {
+		hash.Write([]byte(filepath.ToSlash(file)))
+		hash.Write([]byte{0})
+		f, err := os.Open(filepath.Join(out, file))
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+var nodeBuiltins = []string{
+	"assert", "async_hooks", "buffer", "child_process", "cluster", "console",
+	"constants", "crypto", "dgram", "diagnostics_channel", "dns", "domain",
+	"events", "fs", "http", "http2", "https", "inspector", "module", "net",
+	"os", "path", "perf_hooks", "process", "punycode", "querystring",
+	"readline", "repl", "stream", "string_decoder", "sys", "timers", "tls",
+	"trace_events", "tty", "url", "util", "v8", "vm", "wasi", "worker_threads",
+	"zlib",
+}
+
+// isNodeBuiltin reports whether the import path is a node builtin like fs,
+// node:crypto, or fs/promises.
+func isNodeBuiltin(path string) bool {
+	path = strings.TrimPrefix(path, "node:")
+	return slices.Contains(nodeBuiltins, strings.Split(path, "/")[0])
+}
+
+// externalPackages returns the sorted names of packages imported by the
+// bundle but left external, reducing subpath imports like lodash/get to the
+// package. Node builtins, external files, and esbuild's internal <runtime>
+// module are skipped.
+func externalPackages(metafile js.Metafile) []string {
+	seen := map[string]bool{}
+	for _, input := range metafile.Inputs {
+		for _, imp := range input.Imports {
+			if !imp.External || strings.HasPrefix(imp.Path, "node:") || strings.HasPrefix(imp.Path, ".") || strings.HasPrefix(imp.Path, "<") || filepath.IsAbs(imp.Path) {
+				continue
+			}
+			segments := strings.Split(imp.Path, "/")
+			name := segments[0]
+			if strings.HasPrefix(name, "@") && len(segments) > 1 {
+				name += "/" + segments[1]
+			}
+			if slices.Contains(nodeBuiltins, name) {
+				continue
+			}
+			seen[name] = true
+		}
+	}
+	packages := make([]string, 0, len(seen))
+	for name := range seen {
+		packages = append(packages, name)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+// stylesheets returns the sorted absolute paths of the CSS files in the
+// metafile outputs.
+func stylesheets(metafile js.Metafile, root string) []string {
+	files := []string{}
+	for key := range metafile.Outputs {
+		if filepath.Ext(key) == ".css" {
+			files = append(files, filepath.Join(root, key))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// inputFiles returns the sorted absolute paths of the files the bundle was
+// built from, skipping esbuild's virtual modules and plugin namespaces.
+func inputFiles(metafile js.Metafile, root string) []string {
+	files := []string{}
+	for key := range metafile.Inputs {
+		if strings.HasPrefix(key, "<") || strings.Contains(key, ":") {
+			continue
+		}
+		files = append(files, filepath.Join(root, key))
+	}
+	sort.Strings(files)
+	return files
+}
@@ -1,19 +1,29 @@
 package node
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	goruntime "runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
 	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/joho/godotenv"
+	"github.com/sst/ion/internal/fs"
 	"github.com/sst/ion/internal/util"
+	"github.com/sst/ion/pkg/flag"
+	"github.com/sst/ion/pkg/js"
 	"github.com/sst/ion/pkg/project/path"
 	"github.com/sst/ion/pkg/runtime"
 )
@@ -32,133 +42,818 @@ var loaderMap = map[string]api.Loader{
 	"binary":  api.LoaderBinary,
 }
 
+var jsxMap = map[string]api.JSX{
+	"transform": api.JSXTransform,
+	"preserve":  api.JSXPreserve,
+	"automatic": api.JSXAutomatic,
+}
+
+var platformMap = map[string]api.Platform{
+	"node":    api.PlatformNode,
+	"browser": api.PlatformBrowser,
+	"neutral": api.PlatformNeutral,
+}
+
+var charsetMap = map[string]api.Charset{
+	"ascii": api.CharsetASCII,
+	"utf8":  api.CharsetUTF8,
+}
+
+var logLevelMap = map[string]api.LogLevel{
+	"silent":  api.LogLevelSilent,
+	"error":   api.LogLevelError,
+	"warning": api.LogLevelWarning,
+	"info":    api.LogLevelInfo,
+}
+
+var legalCommentsMap = map[string]api.LegalComments{
+	"none":     api.LegalCommentsNone,
+	"inline":   api.LegalCommentsInline,
+	"eof":      api.LegalCommentsEndOfFile,
+	"linked":   api.LegalCommentsLinked,
+	"external": api.LegalCommentsExternal,
+}
+
 type Runtime struct {
 	cfgPath  string
 	contexts map[string]esbuild.BuildContext
 	results  map[string]esbuild.BuildResult
+	// metafiles holds the metafile of the last successful build so rebuild
+	// decisions keep working after a failed intermediate build.
+	metafiles map[string]string
+	// outputs maps each function's lowercased entry output paths to the
+	// source that produced them so collisions on case-insensitive filesystems
+	// can be detected.
+	outputs map[string]map[string]output
+	// builds caches the last successful output per function so unchanged
+	// functions are not rebuilt.
+	builds map[string]cachedBuild
+	// workers holds the workers started by Run until they exit or are
+	// stopped.
+	workers map[*Worker]struct{}
+	// optionKeys holds a key of the options each function's context was
+	// created with, esbuild only reads them then so the context is recreated
+	// when they change.
+	optionKeys map[string]string
+	// defineFiles holds each function's define file for ShouldRebuild.
+	defineFiles map[string]string
+	// stats aggregates rebuild timings per function when profiling.
+	stats   map[string]*BuildStat
+	recent  []string
+	options *Options
+	lock    sync.RWMutex
+	// eventLock delivers build events one at a time.
+	eventLock sync.Mutex
+	// newContext creates esbuild build contexts, replaced in tests.
+	newContext func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError)
 }
 
-func New() *Runtime {
-	return &Runtime{
-		contexts: map[string]esbuild.BuildContext{},
-		results:  map[string]esbuild.BuildResult{},
+type Options struct {
+	// MaxContexts bounds how many esbuild contexts are retained. When exceeded
+	// the least recently built context is disposed. Zero means unbounded. The
+	// CLI sets it from SST_MAX_BUILD_CONTEXTS.
+	MaxContexts int
+	// Command and Args start the worker process, defaulting to node.
+	Command string
+	Args    []string
+	// BuildOptions adjusts the base esbuild options before function
+	// properties are applied, letting other runtimes change the defaults.
+	BuildOptions func(options *esbuild.BuildOptions)
+	// BuildAttempts bounds how many times a build is attempted when esbuild
+	// fails for reasons unrelated to the code, defaulting to 3.
+	BuildAttempts int
+	// Profile records how long each function's rebuilds take, see
+	// Runtime.BuildStats. Defaults to on when SST_PROFILE_BUILDS is set.
+	Profile bool
+	// Installer is the package manager used to install Install packages into
+	// the output directory for deployment, defaulting to npm.
+	Installer string
+	// BuildConcurrency bounds how many functions BuildAll builds at once,
+	// defaulting to the number of CPUs.
+	BuildConcurrency int
+	// OnEvent is called as builds start and finish, see BuildEvent.
+	OnEvent func(event BuildEvent)
+	// ScratchDir receives what builds write besides the bundle, the dev
+	// node_modules link and the installer cache, in a directory per function
+	// instead of the output directory. Workers find the link through
+	// NODE_PATH, which node only applies to require, so esm imports of
+	// externals resolve from node_modules above the output as usual. Empty
+	// keeps everything in the output directory.
+	ScratchDir string
+}
+
+type Option func(*Options)
+
+func WithCommand(command string, args ...string) Option {
+	return func(opts *Options) {
+		opts.Command = command
+		opts.Args = args
+	}
+}
+
+func WithBuildOptions(fn func(options *esbuild.BuildOptions)) Option {
+	return func(opts *Options) {
+		opts.BuildOptions = fn
+	}
+}
+
+func WithInstaller(installer string) Option {
+	return func(opts *Options) {
+		opts.Installer = installer
 	}
 }
 
-type Worker struct {
-	stdout io.ReadCloser
-	stderr io.ReadCloser
-	cmd    *exec.Cmd
+func WithBuildAttempts(attempts int) Option {
+	return func(opts *Options) {
+		opts.BuildAttempts = attempts
+	}
+}
+
+func WithProfile(profile bool) Option {
+	return func(opts *Options) {
+		opts.Profile = profile
+	}
+}
+
+func WithBuildConcurrency(concurrency int) Option {
+	return func(opts *Options) {
+		opts.BuildConcurrency = concurrency
+	}
+}
+
+func WithOnEvent(fn func(event BuildEvent)) Option {
+	return func(opts *Options) {
+		opts.OnEvent = fn
+	}
+}
+
+func WithScratchDir(dir string) Option {
+	return func(opts *Options) {
+		opts.ScratchDir = dir
+	}
+}
+
+func WithMaxContexts(max int) Option {
+	return func(opts *Options) {
+		opts.MaxContexts = max
+	}
+}
+
+func New(options ...Option) *Runtime {
+	opts := &Options{
+		Command:          "node",
+		Args:             []string{"--enable-source-maps"},
+		Installer:        "npm",
+		BuildAttempts:    3,
+		Profile:          flag.SST_PROFILE_BUILDS,
+		BuildConcurrency: goruntime.NumCPU(),
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return &Runtime{
+		contexts:    map[string]esbuild.BuildContext{},
+		results:     map[string]esbuild.BuildResult{},
+		metafiles:   map[string]string{},
+		outputs:     map[string]map[string]output{},
+		builds:      map[string]cachedBuild{},
+		workers:     map[*Worker]struct{}{},
+		optionKeys:  map[string]string{},
+		defineFiles: map[string]string{},
+		stats:       map[string]*BuildStat{},
+		options:     opts,
+		newContext:  esbuild.Context,
+	}
 }
 
-func (w *Worker) Stop() {
-	// Terminate the whole process group
-	util.TerminateProcess(w.cmd.Process.Pid)
+// scratchDir returns the function's directory under ScratchDir, creating it
+// and checking it can be written to.
+func (r *Runtime) scratchDir(functionID string) (string, error) {
+	dir := filepath.Join(r.options.ScratchDir, functionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("scratch directory %v is not writable: %w", r.options.ScratchDir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-")
+	if err != nil {
+		return "", fmt.Errorf("scratch directory %v is not writable: %w", r.options.ScratchDir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return dir, nil
 }
 
-func (w *Worker) Logs() io.ReadCloser {
-	reader, writer := io.Pipe()
+type output struct {
+	target string
+	source string
+}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(writer, w.stdout)
-	}()
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(writer, w.stderr)
-	}()
+// claimOutputs replaces the entry outputs claimed by the function, failing
+// when two of them, or one of them and an output of another function, differ
+// only in case and so collide on case-insensitive filesystems. Outputs of
+// different functions only collide when their output directories do.
+func (r *Runtime) claimOutputs(functionID string, claims []output) error {
+	keys := map[string]output{}
+	for _, claim := range claims {
+		key := strings.ToLower(claim.target)
+		if existing, ok := keys[key]; ok && existing.source != claim.source {
+			return fmt.Errorf("output %v from %v collides with %v from %v on case-insensitive filesystems", claim.target, claim.source, existing.target, existing.source)
+		}
+		keys[key] = claim
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, outputs := range r.outputs {
+		if id == functionID {
+			continue
+		}
+		for key, claim := range keys {
+			if existing, ok := outputs[key]; ok && existing.target != claim.target {
+				return fmt.Errorf("output %v from %v collides with %v from %v on case-insensitive filesystems", claim.target, claim.source, existing.target, existing.source)
+			}
+		}
+	}
+	r.outputs[functionID] = keys
+	return nil
+}
 
-	go func() {
-		wg.Wait()
-		defer writer.Close()
-	}()
+// Reset disposes the function's build context and drops its cached output,
+// so the next Build resolves everything from scratch. Use it when the
+// context went stale, like after installing a dependency. Unknown functions
+// are ignored.
+func (r *Runtime) Reset(functionID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if buildContext, ok := r.contexts[functionID]; ok {
+		slog.Info("disposing build context", "functionID", functionID)
+		buildContext.Dispose()
+		delete(r.contexts, functionID)
+	}
+	delete(r.builds, functionID)
+	delete(r.outputs, functionID)
+	r.recent = slices.DeleteFunc(r.recent, func(id string) bool { return id == functionID })
+}
 
-	return reader
+// touch marks the function as most recently built and disposes the least
+// recently built contexts beyond MaxContexts. The caller must hold the lock.
+func (r *Runtime) touch(functionID string) {
+	r.recent = slices.DeleteFunc(r.recent, func(id string) bool { return id == functionID })
+	r.recent = append(r.recent, functionID)
+	if r.options.MaxContexts <= 0 {
+		return
+	}
+	for len(r.recent) > r.options.MaxContexts {
+		evicted := r.recent[0]
+		r.recent = r.recent[1:]
+		if buildContext, ok := r.contexts[evicted]; ok {
+			slog.Info("disposing build context", "functionID", evicted)
+			buildContext.Dispose()
+			delete(r.contexts, evicted)
+		}
+	}
 }
 
 type NodeProperties struct {
-	Loader       map[string]string `json:"loader"`
-	Install      []string
-	Banner       string
-	ESBuild      esbuild.BuildOptions `json:"esbuild"`
-	Minify       bool                 `json:"minify"`
-	Format       string               `json:"format"`
-	SourceMap    bool                 `json:"sourceMap"`
-	Splitting    bool                 `json:"splitting"`
-	Plugins      string               `json:"plugins"`
-	Architecture string               `json:"architecture"`
+	Loader                map[string]string `json:"loader"`
+	Install               []string
+	Banner                OutputText           `json:"banner"`
+	Footer                OutputText           `json:"footer"`
+	ESBuild               esbuild.BuildOptions `json:"esbuild"`
+	Minify                bool                 `json:"minify"`
+	Format                string               `json:"format"`
+	SourceMap             bool                 `json:"sourceMap"`
+	Splitting             bool                 `json:"splitting"`
+	Plugins               PluginConfigs        `json:"plugins"`
+	Architecture          string               `json:"architecture"`
+	DetectCircularImports bool                 `json:"detectCircularImports"`
+	BundleDependencies    *bool                `json:"bundleDependencies"`
+	Define                map[string]string    `json:"define"`
+	DefineFile            string               `json:"defineFile"`
+	Target                string               `json:"target"`
+	JSX                   string               `json:"jsx"`
+	JSXFactory            string               `json:"jsxFactory"`
+	JSXFragment           string               `json:"jsxFragment"`
+	JSXImportSource       string               `json:"jsxImportSource"`
+	// JSXDev switches the automatic runtime to jsx-dev-runtime with source
+	// locations. Defaults to on in dev when jsx is automatic.
+	JSXDev          *bool             `json:"jsxDev"`
+	Tsconfig        string            `json:"tsconfig"`
+	TsconfigRaw     string            `json:"tsconfigRaw"`
+	Alias           map[string]string `json:"alias"`
+	LowercaseOutput bool              `json:"lowercaseOutput"`
+	// ResolveExtensions replaces esbuild's default extension order, the first
+	// extension that exists wins so list more specific ones first.
+	ResolveExtensions []string `json:"resolveExtensions"`
+	WriteMetafile     bool     `json:"writeMetafile"`
+	LegalComments     string   `json:"legalComments"`
+	Inject            []string `json:"inject"`
+	// TreeShaking overrides esbuild's default of tree shaking bundles. Imports
+	// of packages marked "sideEffects": false in their package.json are still
+	// dropped when unused, even with tree shaking disabled.
+	TreeShaking *bool `json:"treeShaking"`
+	// EntryNames, ChunkNames, and AssetNames are esbuild output path templates
+	// such as "[dir]/[name]-[hash]".
+	EntryNames string `json:"entryNames"`
+	ChunkNames string `json:"chunkNames"`
+	AssetNames string `json:"assetNames"`
+	// PublicPath prefixes the urls of assets emitted by the file loader.
+	PublicPath string `json:"publicPath"`
+	// SkipInstall leaves installing Install packages into the output to the
+	// caller, for example when deploying with a prebuilt layer.
+	SkipInstall bool   `json:"skipInstall"`
+	Charset     string `json:"charset"`
+	// Pure lists global calls, such as "console.log", that can be dropped
+	// when their result is unused.
+	Pure []string `json:"pure"`
+	// Conditions are extra package.json exports conditions to resolve, such as
+	// "development", on top of esbuild's platform defaults.
+	Conditions []string `json:"conditions"`
+	// Platform is node, browser, or neutral. The node require shim, main
+	// fields, and default externals only apply to node. Neutral has no main
+	// fields either, so packages resolve through exports unless MainFields
+	// is set.
+	Platform string `json:"platform"`
+	// NodePaths are extra directories, relative to the project root, to
+	// resolve bare imports from like NODE_PATH.
+	NodePaths []string `json:"nodePaths"`
+	// Supported overrides whether esbuild may emit individual syntax features,
+	// such as "bigint" or "optional-chain", when Target is too coarse.
+	Supported map[string]bool `json:"supported"`
+	// IgnoreAnnotations makes esbuild ignore /* @__PURE__ */ comments and
+	// package.json sideEffects fields, for packages that get them wrong.
+	IgnoreAnnotations *bool `json:"ignoreAnnotations"`
+	// Archive zips the output next to it for deployment, see
+	// BuildOutput.Archive.
+	Archive bool `json:"archive"`
+	// DefaultExternals replaces the packages that are always left external,
+	// sharp and pg-native by default, an empty list disables them. They are
+	// installed into the output when imported, except entries ending in *,
+	// like "@aws-sdk/*", which are expected to be provided by the runtime.
+	DefaultExternals []string `json:"defaultExternals"`
+	// KeepNames preserves function and class names for stack traces at the
+	// cost of bundle size, defaults to true.
+	KeepNames *bool `json:"keepNames"`
+	// Bundle inlines imported modules into the output, defaults to true. When
+	// disabled the handler is only transformed, imports are left as written
+	// and externals no longer apply.
+	Bundle *bool `json:"bundle"`
+	// LogLevel is silent, error, warning, or info and controls what esbuild
+	// prints itself, defaults to silent. Messages are always returned in the
+	// build output.
+	LogLevel string `json:"logLevel"`
+	// MainFields replaces the package.json fields tried when resolving a
+	// package, ["module", "main"] for esm and ["main"] for cjs by default.
+	MainFields []string `json:"mainFields"`
+	// BrowserField honors the package.json browser field, including maps that
+	// swap modules for browser versions. It defaults to on for the browser
+	// platform, can be turned off there for packages with broken maps, and
+	// turned on for neutral, which then resolves packages like browser.
+	BrowserField *bool `json:"browserField"`
+	// OutExtension replaces the output file extension, .mjs for esm, .cjs for
+	// cjs and .js for iife by default.
+	OutExtension string `json:"outExtension"`
+	// TypeModule writes a package.json with type module into the output
+	// directory so node loads esm output with a .js extension as esm even
+	// under a commonjs package.json. Not needed, and skipped, for .mjs and cjs.
+	TypeModule bool `json:"typeModule"`
+	// PostBuild is a shell command run in the output directory after a build
+	// without errors, failing the build when it exits non-zero.
+	PostBuild string `json:"postBuild"`
+	// Copy lists files esbuild does not bundle, such as schemas or config,
+	// to place in the output after a build without errors.
+	Copy []runtime.CopyFile `json:"copy"`
+	// OutputName replaces the output path derived from the handler file, such
+	// as "index" to always emit index.mjs. It is relative to the output
+	// directory and has no extension.
+	OutputName string `json:"outputName"`
+	// Timeout bounds how long a build may take, such as "30s", so a stuck
+	// plugin fails the build instead of stalling it. Unset means no limit.
+	Timeout string `json:"timeout"`
+	// VirtualEntry builds the handler from source in memory instead of
+	// looking for the handler file, for generated wrappers.
+	VirtualEntry *VirtualEntry `json:"virtualEntry"`
+	// AllowOverwrite lets the output replace a file the build reads, which
+	// happens when functions share an output directory and import each
+	// other's output. The input is gone after the build, so only use it when
+	// that file is generated.
+	AllowOverwrite *bool `json:"allowOverwrite"`
+	// GlobalName is the variable an iife bundle assigns its exports to, such
+	// as "handlers" or "sst.handlers".
+	GlobalName string `json:"globalName"`
+}
+
+// OutputText is a banner or footer keyed by output type, "js" or "css". A
+// plain string is accepted as the js text.
+type OutputText map[string]string
+
+func (o *OutputText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*o = OutputText{"js": text}
+		return nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*o = values
+	return nil
+}
+
+// parseProperties decodes the function properties, failing on mistyped
+// fields and returning a warning for fields that are not recognized.
+func parseProperties(raw json.RawMessage) (NodeProperties, []string, error) {
+	var properties NodeProperties
+	if len(raw) == 0 {
+		return properties, nil, nil
+	}
+	// The esbuild passthrough is decoded on its own so that options written
+	// the way esbuild's JS API takes them, such as target: "es2020", only
+	// drop the options that do not fit instead of failing the build.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return properties, nil, fmt.Errorf("invalid function properties: %w", err)
+	}
+	var passthrough json.RawMessage
+	for key, value := range fields {
+		if strings.EqualFold(key, "esbuild") {
+			passthrough = value
+			delete(fields, key)
+		}
+	}
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return properties, nil, err
+	}
+	if err := json.Unmarshal(rest, &properties); err != nil {
+		return properties, nil, fmt.Errorf("invalid function properties: %w", err)
+	}
+	warnings := []string{}
+	decoder := json.NewDecoder(bytes.NewReader(rest))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&NodeProperties{}); err != nil {
+		warnings = append(warnings, "ignoring function properties: "+strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if len(passthrough) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(passthrough))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&properties.ESBuild); err != nil {
+			warnings = append(warnings, "ignoring esbuild options: "+strings.TrimPrefix(err.Error(), "json: "))
+		}
+	}
+	return properties, warnings, nil
 }
 
 var NODE_EXTENSIONS = []string{".ts", ".tsx", ".mts", ".cts", ".js", ".jsx", ".mjs", ".cjs"}
 
+// handlerExtensions returns the extensions a handler file may have, starting
+// with any configured resolve extensions and extensions registered with a
+// code loader, followed by the NODE_EXTENSIONS defaults.
+func handlerExtensions(properties NodeProperties) []string {
+	extensions := []string{}
+	for _, ext := range properties.ResolveExtensions {
+		if !slices.Contains(extensions, ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	keys := make([]string, 0, len(properties.Loader))
+	for key := range properties.Loader {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, ext := range keys {
+		switch properties.Loader[ext] {
+		case "js", "jsx", "ts", "tsx":
+			if strings.HasPrefix(ext, ".") && !slices.Contains(extensions, ext) {
+				extensions = append(extensions, ext)
+			}
+		}
+	}
+	for _, ext := range NODE_EXTENSIONS {
+		if !slices.Contains(extensions, ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
 func (r *Runtime) Run(ctx context.Context, input *runtime.RunInput) (runtime.Worker, error) {
-	cmd := exec.CommandContext(
-		ctx,
-		"node",
-		"--enable-source-maps",
-		filepath.Join(
-			path.ResolvePlatformDir(input.CfgPath),
-			"/dist/nodejs-runtime/index.js",
-		),
+	entrypoint := filepath.Join(path.ResolvePlatformDir(input.CfgPath), "/dist/nodejs-runtime/index.js")
+	if input.Entrypoint != "" {
+		entrypoint = input.Entrypoint
+		if !filepath.IsAbs(entrypoint) {
+			entrypoint = filepath.Join(path.ResolveRootDir(input.CfgPath), entrypoint)
+		}
+		if _, err := os.Stat(entrypoint); err != nil {
+			return nil, fmt.Errorf("runtime entrypoint for function %v not found: %v", input.FunctionID, entrypoint)
+		}
+	}
+	args := append([]string{}, r.options.Args...)
+	args = append(args,
+		entrypoint,
 		filepath.Join(input.Build.Out, input.Build.Handler),
 		input.WorkerID,
 	)
+	cmd := exec.CommandContext(ctx, r.options.Command, args...)
 	util.SetProcessGroupID(cmd)
 	util.SetProcessCancel(cmd)
-	cmd.Env = input.Env
-	cmd.Env = append(cmd.Env, "NODE_OPTIONS="+os.Getenv("NODE_OPTIONS"))
+	env, err := loadEnvFile(input)
+	if err != nil {
+		return nil, fmt.Errorf("function %v: %w", input.FunctionID, err)
+	}
+	if r.options.ScratchDir != "" {
+		link := filepath.Join(r.options.ScratchDir, input.FunctionID, "node_modules")
+		if _, err := os.Stat(link); err == nil {
+			cmd.Env = append(cmd.Env, "NODE_PATH="+link)
+		}
+	}
+	nodeOptions := []string{os.Getenv("NODE_OPTIONS")}
+	for _, entry := range append(env, input.Env...) {
+		if value, ok := strings.CutPrefix(entry, "NODE_OPTIONS="); ok {
+			nodeOptions = append(nodeOptions, value)
+			continue
+		}
+		cmd.Env = append(cmd.Env, entry)
+	}
+	if merged := mergeNodeOptions(nodeOptions...); merged != "" {
+		cmd.Env = append(cmd.Env, "NODE_OPTIONS="+merged)
+	}
 	cmd.Env = append(cmd.Env, "VSCODE_INSPECTOR_OPTIONS="+os.Getenv("VSCODE_INSPECTOR_OPTIONS"))
 	cmd.Env = append(cmd.Env, "AWS_LAMBDA_RUNTIME_API="+input.Server)
-	slog.Info("starting worker", "env", cmd.Env, "args", cmd.Args)
+	secrets := []string{}
+	for _, entry := range cmd.Env {
+		if name, value, ok := strings.Cut(entry, "="); ok && slices.Contains(input.Redact, name) {
+			secrets = append(secrets, value)
+		}
+	}
+	slog.Info("starting worker", "functionID", input.FunctionID, "handler", input.Build.Handler, "workerID", input.WorkerID, "env", redactEnv(cmd.Env, input.Redact), "args", cmd.Args)
 	cmd.Dir = input.Build.Out
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	cmd.Start()
-	return &Worker{
-		stdout,
-		stderr,
-		cmd,
-	}, nil
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	processStdout, processStdoutWriter := io.Pipe()
+	var processOut, processErr io.WriteCloser = processStdoutWriter, stderrWriter
+	if len(secrets) > 0 {
+		processOut = newRedactWriter(processStdoutWriter, secrets)
+		processErr = newRedactWriter(stderrWriter, secrets)
+	}
+	cmd.Stdout = processOut
+	cmd.Stderr = processErr
+	if err := cmd.Start(); err != nil {
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		processStdoutWriter.Close()
+		slog.Error("failed to start worker", "functionID", input.FunctionID, "handler", input.Build.Handler, "workerID", input.WorkerID, "error", err)
+		return nil, fmt.Errorf("failed to start worker for function %v (%v): %w", input.FunctionID, input.Build.Handler, err)
+	}
+	if err := util.AttachProcessGroup(cmd); err != nil {
+		slog.Warn("failed to attach worker process group, its children may outlive it", "functionID", input.FunctionID, "workerID", input.WorkerID, "err", err)
+	}
+	worker := &Worker{
+		stdout:     stdoutReader,
+		stderr:     stderrReader,
+		cmd:        cmd,
+		done:       make(chan struct{}),
+		ready:      make(chan struct{}),
+		functionID: input.FunctionID,
+		workerID:   input.WorkerID,
+		started:    time.Now(),
+	}
+	worker.release = func() {
+		r.lock.Lock()
+		delete(r.workers, worker)
+		r.lock.Unlock()
+	}
+	r.lock.Lock()
+	r.workers[worker] = struct{}{}
+	r.lock.Unlock()
+	go worker.relayStdout(processStdout, stdoutWriter)
+	go worker.wait(processOut, processErr)
+	return worker, nil
+}
+
+// Workers lists the running workers started by Run, oldest first.
+func (r *Runtime) Workers() []WorkerInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	infos := make([]WorkerInfo, 0, len(r.workers))
+	for worker := range r.workers {
+		infos = append(infos, worker.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Started.Equal(infos[j].Started) {
+			return infos[i].WorkerID < infos[j].WorkerID
+		}
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// StopAll stops every running worker started by Run.
+func (r *Runtime) StopAll() {
+	r.lock.RLock()
+	workers := make([]*Worker, 0, len(r.workers))
+	for worker := range r.workers {
+		workers = append(workers, worker)
+	}
+	r.lock.RUnlock()
+	for _, worker := range workers {
+		worker.Stop()
+	}
+}
+
+var repeatableNodeOptions = []string{"--require", "--import", "--loader", "--experimental-loader"}
+
+// mergeNodeOptions combines NODE_OPTIONS values in order, dropping duplicate
+// flags. A later --flag=value replaces an earlier one with the same name
+// unless the flag can be repeated, like --require.
+func mergeNodeOptions(values ...string) string {
+	flags := []string{}
+	for _, value := range values {
+		for _, flag := range strings.Fields(value) {
+			name, _, hasValue := strings.Cut(flag, "=")
+			index := slices.IndexFunc(flags, func(existing string) bool {
+				if existing == flag {
+					return true
+				}
+				existingName, _, _ := strings.Cut(existing, "=")
+				return hasValue && existingName == name && !slices.Contains(repeatableNodeOptions, name)
+			})
+			if index == -1 {
+				flags = append(flags, flag)
+				continue
+			}
+			flags[index] = flag
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+// loadEnvFile reads the worker's dotenv file, returning nothing when no file
+// was configured and the project has no .env.
+func loadEnvFile(input *runtime.RunInput) ([]string, error) {
+	root := path.ResolveRootDir(input.CfgPath)
+	file := filepath.Join(root, ".env")
+	if input.EnvFile != "" {
+		file = input.EnvFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(root, file)
+		}
+	} else if _, err := os.Stat(file); err != nil {
+		return nil, nil
+	}
+	values, err := godotenv.Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file %v: %w", file, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, key := range keys {
+		env = append(env, key+"="+values[key])
+	}
+	return env, nil
 }
 
 func (r *Runtime) Match(runtime string) bool {
 	return strings.HasPrefix(runtime, "node")
 }
 
-func (r *Runtime) getFile(input *runtime.BuildInput) (string, bool) {
+// getFile resolves the handler to its source file. The handler may name a
+// nested export, like src/index.nested.handler, so the longest prefix that
+// exists as a file wins.
+func (r *Runtime) getFile(input *runtime.BuildInput, extensions []string) (string, error) {
 	dir := filepath.Dir(input.Handler)
 	fileSplit := strings.Split(filepath.Base(input.Handler), ".")
-	base := strings.Join(fileSplit[:len(fileSplit)-1], ".")
-	for _, ext := range NODE_EXTENSIONS {
-		file := filepath.Join(path.ResolveRootDir(input.CfgPath), dir, base+ext)
-		if _, err := os.Stat(file); err == nil {
-			return file, true
+	searched := []string{}
+	for i := len(fileSplit) - 1; i > 0; i-- {
+		base := strings.Join(fileSplit[:i], ".")
+		for _, ext := range extensions {
+			file := filepath.Join(path.ResolveRootDir(input.CfgPath), dir, base+ext)
+			if _, err := os.Stat(file); err == nil {
+				return file, nil
+			}
+			searched = append(searched, file)
+		}
+	}
+	return "", fmt.Errorf("Handler not found: %v, searched:\n%v", input.Handler, strings.Join(searched, "\n"))
+}
+
+// handlerExport returns the export path of the handler once its file has
+// been resolved, handler for src/index.handler or nested.handler for
+// src/index.nested.handler.
+func handlerExport(handler string, file string) string {
+	base := filepath.Base(handler)
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if strings.HasPrefix(base, name+".") {
+		return strings.TrimPrefix(base, name+".")
+	}
+	return base[strings.LastIndex(base, ".")+1:]
+}
+
+// getFiles resolves the handler to one or more entry points. Handlers
+// containing glob characters, like src/handlers/*.handler, can match multiple
+// files.
+func (r *Runtime) getFiles(input *runtime.BuildInput, extensions []string) ([]string, error) {
+	if !strings.ContainsAny(input.Handler, "*?[") {
+		file, err := r.getFile(input, extensions)
+		if err != nil {
+			return nil, err
+		}
+		return []string{file}, nil
+	}
+
+	root := path.ResolveRootDir(input.CfgPath)
+	patterns := []string{filepath.Join(root, input.Handler)}
+	dir := filepath.Dir(input.Handler)
+	fileSplit := strings.Split(filepath.Base(input.Handler), ".")
+	if len(fileSplit) > 1 {
+		base := strings.Join(fileSplit[:len(fileSplit)-1], ".")
+		for _, ext := range extensions {
+			patterns = append(patterns, filepath.Join(root, dir, base+ext))
+		}
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{}
+		for _, match := range matches {
+			for _, ext := range extensions {
+				if strings.HasSuffix(match, ext) {
+					files = append(files, match)
+					break
+				}
+			}
+		}
+		if len(files) > 0 {
+			sort.Strings(files)
+			return files, nil
 		}
 	}
-	return "", false
+	return nil, fmt.Errorf("No handlers matched %v, searched:\n%v", input.Handler, strings.Join(patterns, "\n"))
+}
+
+// Metafile returns the esbuild metafile JSON of the function's last
+// successful build and whether there is one.
+func (r *Runtime) Metafile(functionID string) (string, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	metafile, ok := r.metafiles[functionID]
+	return metafile, ok
 }
 
 func (r *Runtime) ShouldRebuild(functionID string, file string) bool {
-	result, ok := r.results[functionID]
+	r.lock.RLock()
+	metafile, ok := r.metafiles[functionID]
+	cfgPath := r.cfgPath
+	r.lock.RUnlock()
 	if !ok {
-		return false
+		slog.Info("no metafile for function, rebuilding to be safe", "functionID", functionID)
+		return true
 	}
+	root := path.ResolveRootDir(cfgPath)
 
-	var meta = map[string]interface{}{}
-	err := json.Unmarshal([]byte(result.Metafile), &meta)
-	if err != nil {
-		return false
+	if cfg, err := filepath.Abs(cfgPath); err == nil && cfg == file {
+		return true
+	}
+
+	r.lock.RLock()
+	defineFile := r.defineFiles[functionID]
+	r.lock.RUnlock()
+	if defineFile != "" && defineFile == file {
+		return true
+	}
+
+	var meta js.Metafile
+	err := json.Unmarshal([]byte(metafile), &meta)
+	if err != nil || meta.Inputs == nil {
+		slog.Info("invalid metafile for function, rebuilding to be safe", "functionID", functionID, "err", err)
+		return true
 	}
-	for key := range meta["inputs"].(map[string]interface{}) {
-		absPath, err := filepath.Abs(key)
+	isPackageJson := filepath.Base(file) == "package.json"
+	checked := map[string]bool{}
+	for key := range meta.Inputs {
+		absPath, err := filepath.Abs(filepath.Join(root, key))
 		if err != nil {
 			continue
 		}
 		if absPath == file {
 			return true
 		}
+		// A package.json is only relevant when it is the nearest one to an
+		// input, which is the one node and esbuild resolve against.
+		dir := filepath.Dir(absPath)
+		if !isPackageJson || checked[dir] || !strings.HasPrefix(dir, filepath.Dir(file)) {
+			continue
+		}
+		checked[dir] = true
+		if nearest, err := fs.FindUp(dir, "package.json"); err == nil && nearest == file {
+			return true
+		}
 	}
 
 	return false
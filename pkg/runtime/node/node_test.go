@@ -0,0 +1,386 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/sst/ion/pkg/js"
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldRebuildAfterFailedBuild(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	file := filepath.Join(filepath.Dir(cfgPath), "src/index.ts")
+	r := New()
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+
+	_, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.True(t, r.ShouldRebuild(input.FunctionID, file))
+
+	require.NoError(t, os.WriteFile(file, []byte(`export const handler = ( => ;`), 0644))
+	out, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, out.Errors)
+	assert.True(t, r.ShouldRebuild(input.FunctionID, file))
+}
+
+func TestGetFiles(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":      `export const handler = () => "index";`,
+		"src/handlers/a.ts": `import { shared } from "../shared"; export const handler = () => shared + "a";`,
+		"src/handlers/b.ts": `import { shared } from "../shared"; export const handler = () => shared + "b";`,
+		"src/shared.ts":     `export const shared = "shared";`,
+	})
+	root := filepath.Dir(cfgPath)
+	r := New()
+
+	files, err := r.getFiles(buildInput(t, cfgPath, "src/index.handler", nil), NODE_EXTENSIONS)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "src/index.ts")}, files)
+
+	input := buildInput(t, cfgPath, "src/handlers/*.handler", nil)
+	files, err = r.getFiles(input, NODE_EXTENSIONS)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "src/handlers/a.ts"),
+		filepath.Join(root, "src/handlers/b.ts"),
+	}, files)
+
+	out, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	assert.FileExists(t, filepath.Join(input.Out(), "src/handlers/a.mjs"))
+	assert.FileExists(t, filepath.Join(input.Out(), "src/handlers/b.mjs"))
+
+	_, err = r.getFiles(buildInput(t, cfgPath, "src/missing/*.handler", nil), NODE_EXTENSIONS)
+	assert.ErrorContains(t, err, filepath.Join(root, "src/missing/*.ts"))
+}
+
+func TestGetFileNotFound(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{})
+	root := filepath.Dir(cfgPath)
+
+	_, err := New().getFile(buildInput(t, cfgPath, "src/missing.handler", nil), NODE_EXTENSIONS)
+	require.Error(t, err)
+	for _, ext := range NODE_EXTENSIONS {
+		assert.ErrorContains(t, err, filepath.Join(root, "src/missing"+ext))
+	}
+}
+
+func TestHandlerExtensions(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.mtsx": `export const handler = () => <div>custom</div>;`,
+	})
+	properties := NodeProperties{
+		Loader: map[string]string{".mtsx": "tsx", ".png": "file"},
+	}
+	extensions := handlerExtensions(properties)
+	assert.Equal(t, ".mtsx", extensions[0])
+	assert.NotContains(t, extensions, ".png")
+	assert.Subset(t, extensions, NODE_EXTENSIONS)
+
+	input := buildInput(t, cfgPath, "src/index.handler", nil)
+	file, err := New().getFile(input, extensions)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(cfgPath), "src/index.mtsx"), file)
+
+	_, err = New().getFile(input, NODE_EXTENSIONS)
+	assert.Error(t, err)
+}
+
+func TestShouldRebuildPackageJson(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"package.json":                    `{"name":"app"}`,
+		"src/index.ts":                    `import { value } from "dep"; export const handler = () => value;`,
+		"src/unused.ts":                   `export const unused = true;`,
+		"node_modules/dep/package.json":   `{"name":"dep","main":"index.js"}`,
+		"node_modules/dep/index.js":       `exports.value = "dep";`,
+		"node_modules/other/package.json": `{"name":"other"}`,
+		"other/package.json":              `{"name":"other"}`,
+	})
+	root := filepath.Dir(cfgPath)
+	r := New()
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+
+	assert.True(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "src/index.ts")))
+	assert.False(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "src/unused.ts")))
+	assert.True(t, r.ShouldRebuild(input.FunctionID, cfgPath))
+	assert.True(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "package.json")))
+	assert.True(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "node_modules/dep/package.json")))
+	assert.False(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "node_modules/other/package.json")))
+	assert.False(t, r.ShouldRebuild(input.FunctionID, filepath.Join(root, "other/package.json")))
+}
+
+func TestShouldRebuildWithoutMetafile(t *testing.T) {
+	r := New()
+	assert.True(t, r.ShouldRebuild("unknown", "/src/index.ts"))
+
+	r.metafiles["corrupt"] = "{not json"
+	assert.True(t, r.ShouldRebuild("corrupt", "/src/index.ts"))
+}
+
+func TestRunEnvFile(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		".env":       "# comment\nFROM_FILE=\"hello world\" # trailing\nOVERRIDDEN=file\n",
+		"custom.env": "CUSTOM='single quoted'\n",
+		"out/.keep":  "",
+	})
+	root := filepath.Dir(cfgPath)
+	r := New(WithCommand("sh", "-c", "env", "sh"))
+	run := func(envFile string) string {
+		worker, err := r.Run(context.Background(), &runtime.RunInput{
+			CfgPath:  cfgPath,
+			WorkerID: "worker",
+			Build:    &runtime.BuildOutput{Out: filepath.Join(root, "out"), Handler: "index.handler"},
+			Env:      []string{"OVERRIDDEN=input"},
+			EnvFile:  envFile,
+		})
+		require.NoError(t, err)
+		logs, err := io.ReadAll(worker.Logs())
+		require.NoError(t, err)
+		return string(logs)
+	}
+
+	logs := run("")
+	assert.Contains(t, logs, "FROM_FILE=hello world\n")
+	assert.Contains(t, logs, "OVERRIDDEN=input\n")
+	assert.NotContains(t, logs, "OVERRIDDEN=file")
+
+	logs = run("custom.env")
+	assert.Contains(t, logs, "CUSTOM=single quoted\n")
+	assert.NotContains(t, logs, "FROM_FILE")
+
+	_, err := r.Run(context.Background(), &runtime.RunInput{
+		CfgPath: cfgPath,
+		Build:   &runtime.BuildOutput{Out: filepath.Join(root, "out")},
+		EnvFile: "missing.env",
+	})
+	assert.ErrorContains(t, err, "failed to read env file")
+}
+
+func TestMergeNodeOptions(t *testing.T) {
+	assert.Equal(t, "", mergeNodeOptions("", ""))
+	assert.Equal(t,
+		"--enable-source-maps --max-old-space-size=512",
+		mergeNodeOptions("--enable-source-maps --max-old-space-size=256", "--max-old-space-size=512 --enable-source-maps"),
+	)
+	assert.Equal(t,
+		"--require=./a.js --require=./b.js",
+		mergeNodeOptions("--require=./a.js", "--require=./b.js --require=./a.js"),
+	)
+}
+
+func TestRunNodeOptions(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	r := New(WithCommand("sh", "-c", "env", "sh"))
+	run := func(env []string) string {
+		worker, err := r.Run(context.Background(), &runtime.RunInput{
+			CfgPath:  cfgPath,
+			WorkerID: "worker",
+			Build:    &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+			Env:      env,
+		})
+		require.NoError(t, err)
+		logs, err := io.ReadAll(worker.Logs())
+		require.NoError(t, err)
+		return string(logs)
+	}
+
+	t.Setenv("NODE_OPTIONS", "--enable-source-maps --max-old-space-size=256")
+	logs := run([]string{"NODE_OPTIONS=--max-old-space-size=512"})
+	assert.Contains(t, logs, "NODE_OPTIONS=--enable-source-maps --max-old-space-size=512\n")
+	assert.Equal(t, 1, strings.Count(logs, "NODE_OPTIONS="))
+
+	t.Setenv("NODE_OPTIONS", "")
+	assert.NotContains(t, run(nil), "NODE_OPTIONS=")
+}
+
+func TestRunLogsFunction(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	input := &runtime.RunInput{
+		CfgPath:    cfgPath,
+		FunctionID: "MyFunction",
+		WorkerID:   "worker",
+		Build:      &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out"), Handler: "src/index.handler"},
+	}
+	_, err := New(WithCommand(filepath.Join(t.TempDir(), "missing"))).Run(context.Background(), input)
+	assert.ErrorContains(t, err, "failed to start worker for function MyFunction (src/index.handler)")
+
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == "starting worker" {
+			found = true
+			assert.Equal(t, "MyFunction", record["functionID"])
+			assert.Equal(t, "src/index.handler", record["handler"])
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestMetafile(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts":  `import { shared } from "./shared"; export const handler = () => shared;`,
+		"src/shared.ts": `export const shared = "ok";`,
+	})
+	r := New()
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	_, ok := r.Metafile(input.FunctionID)
+	assert.False(t, ok)
+
+	_, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	metafile, ok := r.Metafile(input.FunctionID)
+	require.True(t, ok)
+	var parsed js.Metafile
+	require.NoError(t, json.Unmarshal([]byte(metafile), &parsed))
+	assert.Contains(t, parsed.Inputs, "src/index.ts")
+	assert.Contains(t, parsed.Inputs, "src/shared.ts")
+}
+
+type disposingContext struct {
+	esbuild.BuildContext
+	functionID string
+	disposed   *[]string
+}
+
+func (c disposingContext) Dispose() {
+	*c.disposed = append(*c.disposed, c.functionID)
+	c.BuildContext.Dispose()
+}
+
+func TestMaxContexts(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	files := map[string]string{}
+	for _, name := range names {
+		files["src/"+name+".ts"] = `export const handler = () => "` + name + `";`
+	}
+	cfgPath := setupProject(t, files)
+	r := New(WithMaxContexts(2))
+	disposed := []string{}
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		buildContext, err := esbuild.Context(options)
+		if err != nil {
+			return nil, err
+		}
+		functionID := strings.TrimSuffix(filepath.Base(options.Outfile), filepath.Ext(options.Outfile))
+		return disposingContext{buildContext, functionID, &disposed}, nil
+	}
+	inputs := map[string]*runtime.BuildInput{}
+	for _, name := range names {
+		input := buildInput(t, cfgPath, "src/"+name+".handler", map[string]interface{}{})
+		input.FunctionID = name
+		inputs[name] = input
+	}
+	build := func(name string) {
+		_, err := r.Build(context.Background(), inputs[name])
+		require.NoError(t, err, name)
+	}
+
+	build("a")
+	build("b")
+	assert.Empty(t, disposed)
+	build("c")
+	assert.Equal(t, []string{"a"}, disposed)
+	assert.NotContains(t, r.contexts, "a")
+
+	// Building b again makes c the least recently used.
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cfgPath), "src/b.ts"), []byte(`export const handler = () => "b2";`), 0644))
+	build("b")
+	build("d")
+	assert.Equal(t, []string{"a", "c"}, disposed)
+	assert.Len(t, r.contexts, 2)
+	assert.Contains(t, r.contexts, "b")
+	assert.Contains(t, r.contexts, "d")
+}
+
+func TestReset(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `import { value } from "newdep"; export const handler = () => value;`,
+	})
+	r := New()
+	contexts := 0
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		contexts++
+		return esbuild.Context(options)
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{})
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Errors)
+
+	root := filepath.Dir(cfgPath)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "node_modules/newdep"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "node_modules/newdep/index.js"), []byte(`export const value = "installed";`), 0644))
+
+	r.Reset(input.FunctionID)
+	assert.NotContains(t, r.contexts, input.FunctionID)
+	result, err = r.Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, result.Errors)
+	assert.False(t, result.Incremental)
+	assert.Equal(t, 2, contexts)
+	assert.Contains(t, readOutput(t, input, "src/index.mjs"), "installed")
+
+	r.Reset("unknown")
+}
+
+func TestRunEntrypoint(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep":     "",
+		"test/shim.mjs": `console.log("shim", process.argv[2], process.argv[3]);`,
+	})
+	root := filepath.Dir(cfgPath)
+	input := &runtime.RunInput{
+		CfgPath:    cfgPath,
+		FunctionID: "MyFunction",
+		WorkerID:   "worker",
+		Build:      &runtime.BuildOutput{Out: filepath.Join(root, "out"), Handler: "src/index.handler"},
+		Entrypoint: "test/shim.mjs",
+	}
+	worker, err := New().Run(context.Background(), input)
+	require.NoError(t, err)
+	logs, err := io.ReadAll(worker.Logs())
+	require.NoError(t, err)
+	assert.Equal(t, "shim "+filepath.Join(root, "out/src/index.handler")+" worker\n", string(logs))
+
+	input.Entrypoint = "test/missing.mjs"
+	_, err = New().Run(context.Background(), input)
+	assert.ErrorContains(t, err, "runtime entrypoint for function MyFunction not found: "+filepath.Join(root, "test/missing.mjs"))
+}
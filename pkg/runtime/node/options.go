@@ -0,0 +1,195 @@
+package node
+
+import (
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// mergeESBuild overlays the non-zero fields of the esbuild passthrough onto
+// the computed options. Maps are merged with the passthrough winning, except
+// Banner and Footer whose text is appended so the runtime shims are kept.
+// List options that accumulate (External, Inject, NodePaths, Pure, DropLabels)
+// are appended, and everything else is replaced.
+//
+// The passthrough is applied after the runtime defaults but before dedicated
+// NodeProperties fields, so those still take precedence. Fields that control
+// where and how output is produced are owned by Build and are never taken
+// from the passthrough: EntryPoints, Stdin, Outfile, Outdir, Outbase,
+// OutExtension, AbsWorkingDir, Metafile, Write and Plugins.
+func mergeESBuild(options *esbuild.BuildOptions, user esbuild.BuildOptions) {
+	mergeMap := func(dest *map[string]string, src map[string]string) {
+		if len(src) == 0 {
+			return
+		}
+		merged := map[string]string{}
+		for key, value := range *dest {
+			merged[key] = value
+		}
+		for key, value := range src {
+			merged[key] = value
+		}
+		*dest = merged
+	}
+	appendText := func(dest *map[string]string, src map[string]string) {
+		if len(src) == 0 {
+			return
+		}
+		merged := map[string]string{}
+		for key, value := range *dest {
+			merged[key] = value
+		}
+		for key, value := range src {
+			if merged[key] != "" {
+				value = merged[key] + "\n" + value
+			}
+			merged[key] = value
+		}
+		*dest = merged
+	}
+	mergeMap(&options.Define, user.Define)
+	mergeMap(&options.Alias, user.Alias)
+	appendText(&options.Banner, user.Banner)
+	appendText(&options.Footer, user.Footer)
+
+	if len(user.Loader) > 0 {
+		loader := map[string]esbuild.Loader{}
+		for key, value := range options.Loader {
+			loader[key] = value
+		}
+		for key, value := range user.Loader {
+			loader[key] = value
+		}
+		options.Loader = loader
+	}
+	if len(user.Supported) > 0 {
+		supported := map[string]bool{}
+		for key, value := range options.Supported {
+			supported[key] = value
+		}
+		for key, value := range user.Supported {
+			supported[key] = value
+		}
+		options.Supported = supported
+	}
+
+	options.External = append(options.External, user.External...)
+	options.Inject = append(options.Inject, user.Inject...)
+	options.NodePaths = append(options.NodePaths, user.NodePaths...)
+	options.Pure = append(options.Pure, user.Pure...)
+	options.DropLabels = append(options.DropLabels, user.DropLabels...)
+
+	if len(user.Conditions) > 0 {
+		options.Conditions = user.Conditions
+	}
+	if len(user.MainFields) > 0 {
+		options.MainFields = user.MainFields
+	}
+	if len(user.ResolveExtensions) > 0 {
+		options.ResolveExtensions = user.ResolveExtensions
+	}
+	if len(user.Engines) > 0 {
+		options.Engines = user.Engines
+	}
+
+	if user.Target != 0 {
+		options.Target = user.Target
+	}
+	if user.Format != esbuild.FormatDefault {
+		options.Format = user.Format
+	}
+	if user.Platform != esbuild.PlatformDefault {
+		options.Platform = user.Platform
+	}
+	if user.Sourcemap != esbuild.SourceMapNone {
+		options.Sourcemap = user.Sourcemap
+	}
+	if user.SourceRoot != "" {
+		options.SourceRoot = user.SourceRoot
+	}
+	if user.SourcesContent != esbuild.SourcesContentInclude {
+		options.SourcesContent = user.SourcesContent
+	}
+	if user.Packages != esbuild.PackagesDefault {
+		options.Packages = user.Packages
+	}
+	if user.TreeShaking != esbuild.TreeShakingDefault {
+		options.TreeShaking = user.TreeShaking
+	}
+	if user.Charset != esbuild.CharsetDefault {
+		options.Charset = user.Charset
+	}
+	if user.LegalComments != esbuild.LegalCommentsDefault {
+		options.LegalComments = user.LegalComments
+	}
+	if user.LogLevel != esbuild.LogLevelSilent {
+		options.LogLevel = user.LogLevel
+	}
+	if user.Drop != 0 {
+		options.Drop = user.Drop
+	}
+	if user.JSX != esbuild.JSXTransform {
+		options.JSX = user.JSX
+	}
+
+	if user.MinifyWhitespace {
+		options.MinifyWhitespace = true
+	}
+	if user.MinifyIdentifiers {
+		options.MinifyIdentifiers = true
+	}
+	if user.MinifySyntax {
+		options.MinifySyntax = true
+	}
+	if user.IgnoreAnnotations {
+		options.IgnoreAnnotations = true
+	}
+	if user.JSXDev {
+		options.JSXDev = true
+	}
+	if user.AllowOverwrite {
+		options.AllowOverwrite = true
+	}
+
+	for _, field := range []struct {
+		dest *string
+		src  string
+	}{
+		{&options.GlobalName, user.GlobalName},
+		{&options.JSXFactory, user.JSXFactory},
+		{&options.JSXFragment, user.JSXFragment},
+		{&options.JSXImportSource, user.JSXImportSource},
+		{&options.Tsconfig, user.Tsconfig},
+		{&options.TsconfigRaw, user.TsconfigRaw},
+		{&options.PublicPath, user.PublicPath},
+		{&options.EntryNames, user.EntryNames},
+		{&options.ChunkNames, user.ChunkNames},
+		{&options.AssetNames, user.AssetNames},
+	} {
+		if field.src != "" {
+			*field.dest = field.src
+		}
+	}
+}
+
+// normalizeExternal trims and dedupes the external list, keeping the first
+// occurrence of each entry. Wildcard entries, such as "@aws-sdk/*", may only
+// have a single trailing *, others are returned separately as malformed.
+func normalizeExternal(external []string) ([]string, []string) {
+	result := []string{}
+	malformed := []string{}
+	seen := map[string]bool{}
+	for _, entry := range external {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		if wildcard := strings.Index(entry, "*"); wildcard != -1 && (entry == "*" || wildcard != len(entry)-1) {
+			malformed = append(malformed, entry)
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result, malformed
+}
@@ -0,0 +1,85 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeESBuild(t *testing.T) {
+	options := esbuild.BuildOptions{
+		Banner:    map[string]string{"js": "// shim"},
+		External:  []string{"sharp"},
+		Target:    esbuild.ESNext,
+		Format:    esbuild.FormatESModule,
+		Outfile:   "out.mjs",
+		KeepNames: true,
+	}
+	mergeESBuild(&options, esbuild.BuildOptions{
+		Banner:   map[string]string{"js": "// user"},
+		Define:   map[string]string{"DEBUG": "false"},
+		External: []string{"aws-sdk"},
+		Target:   esbuild.ES2020,
+		Outfile:  "ignored.mjs",
+	})
+	assert.Equal(t, "// shim\n// user", options.Banner["js"])
+	assert.Equal(t, map[string]string{"DEBUG": "false"}, options.Define)
+	assert.Equal(t, []string{"sharp", "aws-sdk"}, options.External)
+	assert.Equal(t, esbuild.ES2020, options.Target)
+	assert.Equal(t, esbuild.FormatESModule, options.Format)
+	assert.Equal(t, "out.mjs", options.Outfile)
+	assert.True(t, options.KeepNames)
+}
+
+func TestBuildESBuildPassthrough(t *testing.T) {
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => process.env.STAGE;`,
+	})
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"esbuild": map[string]interface{}{
+			"define": map[string]string{"process.env.STAGE": `"production"`},
+			"footer": map[string]string{"js": "// passthrough footer"},
+		},
+	})
+	out, err := New().Build(context.Background(), input)
+	require.NoError(t, err)
+	assert.Empty(t, out.Errors)
+	output := readOutput(t, input, "src/index.mjs")
+	assert.Contains(t, output, `"production"`)
+	assert.Contains(t, output, "// passthrough footer")
+}
+
+func TestBuildExternalNormalized(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	cfgPath := setupProject(t, map[string]string{
+		"src/index.ts": `export const handler = () => "ok";`,
+	})
+	r := New()
+	var external []string
+	r.newContext = func(options esbuild.BuildOptions) (esbuild.BuildContext, *esbuild.ContextError) {
+		external = options.External
+		return esbuild.Context(options)
+	}
+	input := buildInput(t, cfgPath, "src/index.handler", map[string]interface{}{
+		"install":     []string{"sharp", " lodash ", "lodash"},
+		"skipInstall": true,
+		"esbuild": map[string]interface{}{
+			"external": []string{"@aws-sdk/*", "@smithy/*/*", "pg-native", ""},
+		},
+	})
+	result, err := r.Build(context.Background(), input)
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	assert.Equal(t, []string{"sharp", "pg-native", "node:*", "lodash", "@aws-sdk/*"}, external)
+	assert.Contains(t, buf.String(), "ignoring malformed external")
+	assert.Contains(t, buf.String(), `"external":"@smithy/*/*"`)
+}
@@ -4,9 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sync"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
@@ -16,14 +17,107 @@ type NodeLoadResult struct {
 	Loader string `json:"loader"`
 }
 
-func plugin(path string) api.Plugin {
-	cwd, _ := os.Getwd()
-	path = filepath.Join(cwd, path)
+// PluginConfig is a module, relative to the project root, whose default
+// export is a list of esbuild plugins. When the default export is a function
+// it is called with Options to create them.
+type PluginConfig struct {
+	Path    string                 `json:"path"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// PluginConfigs accepts a single plugin path, as plugins used to be
+// configured, or a list of paths and PluginConfig objects.
+type PluginConfigs []PluginConfig
+
+func (p *PluginConfigs) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = PluginConfigs{}
+		if path != "" {
+			*p = PluginConfigs{{Path: path}}
+		}
+		return nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	configs := PluginConfigs{}
+	for _, item := range items {
+		var config PluginConfig
+		if err := json.Unmarshal(item, &path); err == nil {
+			config.Path = path
+		} else if err := json.Unmarshal(item, &config); err != nil {
+			return err
+		}
+		configs = append(configs, config)
+	}
+	*p = configs
+	return nil
+}
+
+// VirtualEntry is handler source held in memory rather than on disk.
+// Filename is where, relative to the project root, the source is treated as
+// living, its extension picks the loader and imports resolve from its
+// directory, which has to exist.
+type VirtualEntry struct {
+	Filename string `json:"filename"`
+	Contents string `json:"contents"`
+}
+
+var virtualLoaders = map[string]api.Loader{
+	".ts":  api.LoaderTS,
+	".mts": api.LoaderTS,
+	".cts": api.LoaderTS,
+	".tsx": api.LoaderTSX,
+	".jsx": api.LoaderJSX,
+}
+
+// virtualEntryPlugin serves contents for the entry point at file, which does
+// not have to exist.
+func virtualEntryPlugin(file string, contents string) api.Plugin {
+	filter := "^" + regexp.QuoteMeta(file) + "$"
+	loader, ok := virtualLoaders[filepath.Ext(file)]
+	if !ok {
+		loader = api.LoaderJS
+	}
+	return api.Plugin{
+		Name: "virtual-entry",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: filter}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Kind != api.ResolveEntryPoint {
+					return api.OnResolveResult{}, nil
+				}
+				return api.OnResolveResult{Path: file, Namespace: "file"}, nil
+			})
+			build.OnLoad(api.OnLoadOptions{Filter: filter, Namespace: "file"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{
+					Contents:   &contents,
+					ResolveDir: filepath.Dir(file),
+					Loader:     loader,
+				}, nil
+			})
+		},
+	}
+}
+
+// plugin runs the plugins of config in a node process started from script,
+// forwarding esbuild's resolve and load callbacks to it one at a time.
+func plugin(script string, root string, config PluginConfig) api.Plugin {
+	path := filepath.Join(root, config.Path)
 	return api.Plugin{
 		Name: "nodejs-plugin",
 		Setup: func(build api.PluginBuild) {
 			slog.Info("nodejs plugin", "path", path)
-			cmd := exec.Command("node", ".sst/platform/functions/nodejs-runtime/plugin.mjs", path)
+			options, err := json.Marshal(config.Options)
+			if err != nil {
+				build.OnStart(func() (api.OnStartResult, error) {
+					return api.OnStartResult{}, fmt.Errorf("invalid options for plugin %v: %w", config.Path, err)
+				})
+				return
+			}
+			cmd := exec.Command("node", script, path, string(options))
+			cmd.Dir = root
 			stdin, err := cmd.StdinPipe()
 			if err != nil {
 				return
@@ -33,8 +127,16 @@ func plugin(path string) api.Plugin {
 				return
 			}
 			if err := cmd.Start(); err != nil {
+				build.OnStart(func() (api.OnStartResult, error) {
+					return api.OnStartResult{}, fmt.Errorf("failed to start plugin %v: %w", config.Path, err)
+				})
 				return
 			}
+			// esbuild runs callbacks concurrently but the process answers
+			// requests in order over a single pipe.
+			var lock sync.Mutex
+			encoder := json.NewEncoder(stdin)
+			decoder := json.NewDecoder(stdout)
 			build.OnResolve(api.OnResolveOptions{Filter: ".*"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
 				request := map[string]interface{}{
 					"type":      "resolve",
@@ -42,11 +144,13 @@ func plugin(path string) api.Plugin {
 					"importer":  args.Importer,
 					"namespace": args.Namespace,
 				}
-				if err := json.NewEncoder(stdin).Encode(request); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				if err := encoder.Encode(request); err != nil {
 					return api.OnResolveResult{}, fmt.Errorf("error sending resolve request: %w", err)
 				}
 				var result api.OnResolveResult
-				if err := json.NewDecoder(stdout).Decode(&result); err != nil {
+				if err := decoder.Decode(&result); err != nil {
 					return api.OnResolveResult{}, fmt.Errorf("error reading resolve response: %w", err)
 				}
 				slog.Info("result", "result", result)
@@ -58,11 +162,13 @@ func plugin(path string) api.Plugin {
 					"path":      args.Path,
 					"namespace": args.Namespace,
 				}
-				if err := json.NewEncoder(stdin).Encode(request); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				if err := encoder.Encode(request); err != nil {
 					return api.OnLoadResult{}, fmt.Errorf("error sending load request: %w", err)
 				}
 				var nodeResult NodeLoadResult
-				if err := json.NewDecoder(stdout).Decode(&nodeResult); err != nil {
+				if err := decoder.Decode(&nodeResult); err != nil {
 					return api.OnLoadResult{}, fmt.Errorf("error reading load response: %w", err)
 				}
 				nodeResult.OnLoadResult.Loader = loaderMap[nodeResult.Loader]
@@ -72,6 +178,7 @@ func plugin(path string) api.Plugin {
 				stdin.Close()
 				stdout.Close()
 				cmd.Process.Kill()
+				cmd.Wait()
 			})
 		},
 	}
@@ -0,0 +1,107 @@
+package node
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "***"
+
+// redactWriter masks secrets in everything written through it. Output is
+// passed on up to the last newline, beyond that a tail that could be the
+// start of a secret split across writes is held back until more arrives or
+// the writer is closed.
+type redactWriter struct {
+	dst     io.WriteCloser
+	secrets [][]byte
+	keep    int
+	buf     []byte
+	lock    sync.Mutex
+}
+
+func newRedactWriter(dst io.WriteCloser, secrets []string) *redactWriter {
+	w := &redactWriter{dst: dst}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		w.secrets = append(w.secrets, []byte(secret))
+		w.keep = max(w.keep, len(secret)-1)
+	}
+	// Replace longer secrets first so one containing another is fully masked.
+	sort.Slice(w.secrets, func(i, j int) bool { return len(w.secrets[i]) > len(w.secrets[j]) })
+	return w
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	cut := w.safeCut()
+	if cut == 0 {
+		return len(p), nil
+	}
+	if _, err := w.dst.Write(w.redact(w.buf[:cut])); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], w.buf[cut:]...)
+	return len(p), nil
+}
+
+// safeCut returns how much of the buffer can be written without splitting a
+// secret.
+func (w *redactWriter) safeCut() int {
+	cut := len(w.buf) - w.keep
+	if newline := bytes.LastIndexByte(w.buf, '\n'); newline+1 > cut {
+		return newline + 1
+	}
+	if cut <= 0 {
+		return 0
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, secret := range w.secrets {
+			for start := max(0, cut-len(secret)+1); start < cut; start++ {
+				if bytes.HasPrefix(w.buf[start:], secret) && start+len(secret) > cut {
+					cut = start
+					moved = true
+					break
+				}
+			}
+		}
+	}
+	return cut
+}
+
+func (w *redactWriter) redact(data []byte) []byte {
+	for _, secret := range w.secrets {
+		data = bytes.ReplaceAll(data, secret, []byte(redacted))
+	}
+	return data
+}
+
+func (w *redactWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buf) > 0 {
+		_, _ = w.dst.Write(w.redact(w.buf))
+		w.buf = nil
+	}
+	return w.dst.Close()
+}
+
+// redactEnv masks the values of the named variables in env.
+func redactEnv(env []string, names []string) []string {
+	result := make([]string, len(env))
+	for i, entry := range env {
+		result[i] = entry
+		if name, _, ok := strings.Cut(entry, "="); ok && slices.Contains(names, name) {
+			result[i] = name + "=" + redacted
+		}
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bufferCloser struct {
+	data   []byte
+	closed bool
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRedactWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"whole line", []string{"token=hunter22 ok\n"}, "token=*** ok\n"},
+		{"split across writes", []string{"token=hun", "ter", "22 ok\n"}, "token=*** ok\n"},
+		{"split without newline", []string{"a hunter2", "2 b"}, "a *** b"},
+		{"secret containing another", []string{"hunter22-long and hunter22\n"}, "*** and ***\n"},
+		{"no secret", []string{"plain ", "output\n", "tail"}, "plain output\ntail"},
+	}
+	for _, test := range tests {
+		dst := &bufferCloser{}
+		w := newRedactWriter(dst, []string{"hunter22", "hunter22-long", ""})
+		for _, write := range test.writes {
+			n, err := w.Write([]byte(write))
+			require.NoError(t, err, test.name)
+			assert.Equal(t, len(write), n, test.name)
+		}
+		require.NoError(t, w.Close(), test.name)
+		assert.Equal(t, test.want, string(dst.data), test.name)
+		assert.True(t, dst.closed, test.name)
+	}
+}
+
+func TestRedactWriterStreamsLines(t *testing.T) {
+	dst := &bufferCloser{}
+	w := newRedactWriter(dst, []string{"hunter22"})
+	_, err := w.Write([]byte("first line\nsecond"))
+	require.NoError(t, err)
+	assert.Equal(t, "first line\n", string(dst.data))
+}
+
+func TestRunRedact(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	worker, err := New(WithCommand("sh", "-c", `echo "key $API_KEY"; printf "err %s" "$API_KEY" >&2; echo "stage $STAGE"`, "sh")).Run(context.Background(), &runtime.RunInput{
+		CfgPath:  cfgPath,
+		WorkerID: "worker",
+		Build:    &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+		Env:      []string{"API_KEY=sk-live-123", "STAGE=dev"},
+		Redact:   []string{"API_KEY"},
+	})
+	require.NoError(t, err)
+	streams := map[string][]string{}
+	for line := range worker.(*Worker).LogLines() {
+		streams[line.Stream] = append(streams[line.Stream], line.Text)
+	}
+	assert.Equal(t, []string{"key ***", "stage dev"}, streams["stdout"])
+	assert.Equal(t, []string{"err ***"}, streams["stderr"])
+}
+
+func TestRedactEnv(t *testing.T) {
+	assert.Equal(t,
+		[]string{"API_KEY=***", "STAGE=dev", "TOKEN=***"},
+		redactEnv([]string{"API_KEY=secret", "STAGE=dev", "TOKEN=a=b"}, []string{"API_KEY", "TOKEN"}),
+	)
+}
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"sort"
+	"time"
+)
+
+// BuildStat aggregates the esbuild rebuilds of a function while profiling
+// is enabled, see WithProfile.
+type BuildStat struct {
+	FunctionID string
+	Count      int
+	Total      time.Duration
+	Average    time.Duration
+	Max        time.Duration
+	// Size is the output size in bytes of the latest build.
+	Size int64
+}
+
+// recordBuild adds a rebuild to the function's stats when profiling.
+func (r *Runtime) recordBuild(functionID string, duration time.Duration, size int64) {
+	if !r.options.Profile {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stat, ok := r.stats[functionID]
+	if !ok {
+		stat = &BuildStat{FunctionID: functionID}
+		r.stats[functionID] = stat
+	}
+	stat.Count++
+	stat.Total += duration
+	stat.Average = stat.Total / time.Duration(stat.Count)
+	stat.Max = max(stat.Max, duration)
+	stat.Size = size
+}
+
+// BuildStats returns the profiled build stats per function, slowest in total
+// first. It is empty unless profiling is enabled.
+func (r *Runtime) BuildStats() []BuildStat {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	stats := make([]BuildStat, 0, len(r.stats))
+	for _, stat := range r.stats {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total == stats[j].Total {
+			return stats[i].FunctionID < stats[j].FunctionID
+		}
+		return stats[i].Total > stats[j].Total
+	})
+	return stats
+}
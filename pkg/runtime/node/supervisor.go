@@ -0,0 +1,122 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sst/ion/pkg/runtime"
+)
+
+// restartBackoff is the delay before the first restart of a crashed worker,
+// doubling for every restart after that.
+var restartBackoff = 250 * time.Millisecond
+
+// SupervisedWorker relaunches its worker with the same input when it exits
+// abnormally, see RunSupervised.
+type SupervisedWorker struct {
+	runtime  *Runtime
+	ctx      context.Context
+	input    *runtime.RunInput
+	reader   *io.PipeReader
+	writer   *io.PipeWriter
+	current  *Worker
+	restarts int
+	stopped  bool
+	lock     sync.Mutex
+}
+
+// RunSupervised runs a worker like Run, restarting it up to maxRestarts times
+// with backoff when it crashes. Exits caused by Stop or a zero exit code are
+// not restarted. Logs spans every restart.
+func (r *Runtime) RunSupervised(ctx context.Context, input *runtime.RunInput, maxRestarts int) (*SupervisedWorker, error) {
+	worker, err := r.Run(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	supervised := &SupervisedWorker{
+		runtime: r,
+		ctx:     ctx,
+		input:   input,
+		reader:  reader,
+		writer:  writer,
+		current: worker.(*Worker),
+	}
+	go supervised.supervise(maxRestarts)
+	return supervised, nil
+}
+
+func (s *SupervisedWorker) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopped = true
+	s.current.Stop()
+}
+
+func (s *SupervisedWorker) Logs() io.ReadCloser {
+	return s.reader
+}
+
+// Restarts returns how many times the worker has been restarted.
+func (s *SupervisedWorker) Restarts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.restarts
+}
+
+func (s *SupervisedWorker) supervise(maxRestarts int) {
+	defer s.writer.Close()
+	delay := restartBackoff
+	for {
+		s.lock.Lock()
+		worker := s.current
+		s.lock.Unlock()
+
+		io.Copy(s.writer, worker.Logs())
+		<-worker.done
+		exit := worker.exit
+		if exit.StoppedByUs || (exit.Code == 0 && exit.Signal == "") {
+			return
+		}
+
+		s.lock.Lock()
+		restarts := s.restarts
+		stopped := s.stopped
+		s.lock.Unlock()
+		if stopped {
+			return
+		}
+		if restarts >= maxRestarts {
+			slog.Error("worker keeps crashing, not restarting", "functionID", s.input.FunctionID, "workerID", s.input.WorkerID, "restarts", restarts)
+			fmt.Fprintf(s.writer, "worker crashed %d times, not restarting\n", restarts+1)
+			return
+		}
+		slog.Warn("worker crashed, restarting", "functionID", s.input.FunctionID, "workerID", s.input.WorkerID, "code", exit.Code, "signal", exit.Signal, "delay", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return
+		}
+		delay *= 2
+
+		s.lock.Lock()
+		if s.stopped {
+			s.lock.Unlock()
+			return
+		}
+		next, err := s.runtime.Run(s.ctx, s.input)
+		if err != nil {
+			s.lock.Unlock()
+			slog.Error("failed to restart worker", "functionID", s.input.FunctionID, "workerID", s.input.WorkerID, "error", err)
+			return
+		}
+		s.current = next.(*Worker)
+		s.restarts++
+		s.lock.Unlock()
+	}
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSupervised(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	previous := restartBackoff
+	restartBackoff = time.Millisecond
+	t.Cleanup(func() { restartBackoff = previous })
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	script := `if [ -f crashed ]; then echo up; sleep 10; else touch crashed; echo crash; exit 1; fi`
+	r := New(WithCommand("sh", "-c", script, "sh"))
+	worker, err := r.RunSupervised(context.Background(), &runtime.RunInput{
+		CfgPath:  cfgPath,
+		WorkerID: "worker",
+		Build:    &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+	}, 3)
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(worker.Logs())
+	require.True(t, scanner.Scan())
+	assert.Equal(t, "crash", scanner.Text())
+	require.True(t, scanner.Scan())
+	assert.Equal(t, "up", scanner.Text())
+	assert.Equal(t, 1, worker.Restarts())
+
+	worker.Stop()
+	rest, err := io.ReadAll(worker.Logs())
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, 1, worker.Restarts())
+}
+
+func TestRunSupervisedCrashLoop(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	previous := restartBackoff
+	restartBackoff = time.Millisecond
+	t.Cleanup(func() { restartBackoff = previous })
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	r := New(WithCommand("sh", "-c", "exit 1", "sh"))
+	worker, err := r.RunSupervised(context.Background(), &runtime.RunInput{
+		CfgPath:  cfgPath,
+		WorkerID: "worker",
+		Build:    &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+	}, 2)
+	require.NoError(t, err)
+	logs, err := io.ReadAll(worker.Logs())
+	require.NoError(t, err)
+	assert.Equal(t, "worker crashed 3 times, not restarting\n", string(logs))
+	assert.Equal(t, 2, worker.Restarts())
+}
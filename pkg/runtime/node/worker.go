@@ -0,0 +1,236 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sst/ion/internal/util"
+)
+
+// readySentinel is printed by the runtime shim once the handler is loaded.
+// It is filtered out of the worker output.
+const readySentinel = "sst:worker:ready"
+
+type Worker struct {
+	stdout     io.ReadCloser
+	stderr     io.ReadCloser
+	cmd        *exec.Cmd
+	done       chan struct{}
+	ready      chan struct{}
+	exit       *ExitInfo
+	stopped    atomic.Bool
+	functionID string
+	workerID   string
+	started    time.Time
+	// release removes the worker from the runtime's registry.
+	release func()
+}
+
+// WorkerInfo describes a running worker, see Runtime.Workers.
+type WorkerInfo struct {
+	FunctionID string
+	WorkerID   string
+	PID        int
+	Started    time.Time
+	Uptime     time.Duration
+}
+
+func (w *Worker) info() WorkerInfo {
+	return WorkerInfo{
+		FunctionID: w.functionID,
+		WorkerID:   w.workerID,
+		PID:        w.cmd.Process.Pid,
+		Started:    w.started,
+		Uptime:     time.Since(w.started),
+	}
+}
+
+// ExitInfo describes how a worker process exited.
+type ExitInfo struct {
+	Code int
+	// Signal is the name of the signal that terminated the process, if any.
+	Signal string
+	// StoppedByUs is set when the exit was initiated by Stop.
+	StoppedByUs bool
+	Err         error
+}
+
+// stopGrace is how long Stop lets the worker's process group shut down
+// after asking it to before killing it.
+var stopGrace = 2 * time.Second
+
+// killProcessGroup force kills what is left of a stopped worker's group.
+var killProcessGroup = util.KillProcess
+
+func (w *Worker) Stop() {
+	w.stopped.Store(true)
+	w.release()
+	// Terminate the whole process group
+	pid := w.cmd.Process.Pid
+	grace := stopGrace
+	util.TerminateProcess(pid)
+	// Children that ignore the signal or outlive the worker, like a spawned
+	// database engine, would be orphaned. Kill what is left of the group once
+	// the worker exits or the grace period runs out.
+	go func() {
+		select {
+		case <-w.done:
+		case <-time.After(grace):
+		}
+		// Once the worker is reaped its pid can be reused, only kill while
+		// the group still has members holding on to it.
+		if !util.ProcessGroupAlive(pid) {
+			return
+		}
+		killProcessGroup(pid)
+	}()
+}
+
+// Signal forwards the signal to the worker's process group, letting the
+// handler run its shutdown hooks before a hard Stop.
+func (w *Worker) Signal(signal os.Signal) error {
+	if w.cmd.Process == nil {
+		return fmt.Errorf("worker is not running")
+	}
+	return util.SignalProcess(w.cmd.Process.Pid, signal)
+}
+
+func (w *Worker) Logs() io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(writer, w.stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(writer, w.stderr)
+	}()
+
+	go func() {
+		wg.Wait()
+		defer writer.Close()
+	}()
+
+	return reader
+}
+
+// LogLine is a line of worker output tagged with the stream, stdout or
+// stderr, it was written to.
+type LogLine struct {
+	Stream string
+	Text   string
+	Time   time.Time
+}
+
+// LogLines streams the worker output line by line, closing the channel once
+// the worker exits. It reads the same streams as Logs so only one of the two
+// should be used.
+func (w *Worker) LogLines() <-chan LogLine {
+	lines := make(chan LogLine)
+	var wg sync.WaitGroup
+	scan := func(stream string, reader io.Reader) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines <- LogLine{Stream: stream, Text: scanner.Text(), Time: time.Now()}
+		}
+		// Keep draining after an overlong line so the process never blocks
+		// on a full pipe.
+		_, _ = io.Copy(io.Discard, reader)
+	}
+	wg.Add(2)
+	go scan("stdout", w.stdout)
+	go scan("stderr", w.stderr)
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+	return lines
+}
+
+// Ready is closed once the worker has loaded its handler and is polling for
+// invocations. The sentinel is read from stdout so the output has to be
+// consumed through Logs or LogLines. It never closes if the worker exits
+// first, use WaitReady to bound the wait.
+func (w *Worker) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// WaitReady blocks until the worker is ready, failing if it exits or does not
+// become ready within the timeout.
+func (w *Worker) WaitReady(timeout time.Duration) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-w.done:
+		return fmt.Errorf("worker exited before it was ready with code %v", w.exit.Code)
+	case <-time.After(timeout):
+		return fmt.Errorf("worker was not ready after %v", timeout)
+	}
+}
+
+// relayStdout copies the process stdout to the worker's stdout, watching for
+// the ready sentinel until it appears.
+func (w *Worker) relayStdout(src io.Reader, dst *io.PipeWriter) {
+	defer dst.Close()
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == readySentinel {
+			close(w.ready)
+			break
+		}
+		if _, err := io.WriteString(dst, line); err != nil {
+			_, _ = io.Copy(io.Discard, reader)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+	if _, err := io.Copy(dst, reader); err != nil {
+		_, _ = io.Copy(io.Discard, reader)
+	}
+}
+
+// ExitInfo returns how the worker exited, or false if it is still running.
+func (w *Worker) ExitInfo() (*ExitInfo, bool) {
+	select {
+	case <-w.done:
+		return w.exit, true
+	default:
+		return nil, false
+	}
+}
+
+func (w *Worker) wait(stdout io.Closer, stderr io.Closer) {
+	err := w.cmd.Wait()
+	info := &ExitInfo{
+		Code:        -1,
+		StoppedByUs: w.stopped.Load(),
+		Err:         err,
+	}
+	if state := w.cmd.ProcessState; state != nil {
+		info.Code = state.ExitCode()
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			info.Signal = status.Signal().String()
+		}
+	}
+	w.exit = info
+	w.release()
+	close(w.done)
+	stdout.Close()
+	stderr.Close()
+}
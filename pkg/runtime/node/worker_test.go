@@ -0,0 +1,125 @@
+package node
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	goruntime "runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sst/ion/pkg/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runShell(t *testing.T, script string) *Worker {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	worker, err := New(WithCommand("sh", "-c", script, "sh")).Run(context.Background(), &runtime.RunInput{
+		CfgPath:  cfgPath,
+		WorkerID: "worker",
+		Build:    &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+	})
+	require.NoError(t, err)
+	return worker.(*Worker)
+}
+
+func TestWorkerLogLines(t *testing.T) {
+	worker := runShell(t, "echo out; echo err >&2; echo more")
+	streams := map[string][]string{}
+	for line := range worker.LogLines() {
+		assert.False(t, line.Time.IsZero())
+		streams[line.Stream] = append(streams[line.Stream], line.Text)
+	}
+	assert.Equal(t, []string{"out", "more"}, streams["stdout"])
+	assert.Equal(t, []string{"err"}, streams["stderr"])
+	_, exited := worker.ExitInfo()
+	assert.True(t, exited)
+}
+
+func TestWorkerReady(t *testing.T) {
+	worker := runShell(t, "echo booting; echo "+readySentinel+"; echo up; sleep 10")
+	lines := worker.LogLines()
+	assert.Equal(t, "booting", (<-lines).Text)
+	require.NoError(t, worker.WaitReady(5*time.Second))
+	select {
+	case <-worker.Ready():
+	default:
+		t.Fatal("ready channel not closed")
+	}
+	assert.Equal(t, "up", (<-lines).Text)
+	worker.Stop()
+
+	worker = runShell(t, "echo crashed; exit 1")
+	go io.Copy(io.Discard, worker.Logs())
+	assert.ErrorContains(t, worker.WaitReady(5*time.Second), "worker exited before it was ready with code 1")
+
+	worker = runShell(t, "sleep 10")
+	assert.ErrorContains(t, worker.WaitReady(10*time.Millisecond), "not ready")
+	worker.Stop()
+}
+
+func TestWorkerSignal(t *testing.T) {
+	worker := runShell(t, "trap 'echo shutting down; exit 0' INT; echo started; while true; do sleep 0.1; done")
+	lines := worker.LogLines()
+	assert.Equal(t, "started", (<-lines).Text)
+	require.NoError(t, worker.Signal(syscall.SIGINT))
+	assert.Equal(t, "shutting down", (<-lines).Text)
+	for range lines {
+	}
+	exit, exited := worker.ExitInfo()
+	require.True(t, exited)
+	assert.Equal(t, 0, exit.Code)
+	assert.False(t, exit.StoppedByUs)
+}
+
+func TestWorkers(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("worker command is a shell")
+	}
+	cfgPath := setupProject(t, map[string]string{
+		"out/.keep": "",
+	})
+	r := New(WithCommand("sh", "-c", "sleep 30", "sh"))
+	t.Cleanup(r.StopAll)
+	run := func(workerID string) *Worker {
+		worker, err := r.Run(context.Background(), &runtime.RunInput{
+			CfgPath:    cfgPath,
+			FunctionID: "fn",
+			WorkerID:   workerID,
+			Build:      &runtime.BuildOutput{Out: filepath.Join(filepath.Dir(cfgPath), "out")},
+		})
+		require.NoError(t, err)
+		return worker.(*Worker)
+	}
+	first := run("first")
+	second := run("second")
+
+	workers := r.Workers()
+	require.Len(t, workers, 2)
+	assert.Equal(t, "first", workers[0].WorkerID)
+	assert.Equal(t, first.cmd.Process.Pid, workers[0].PID)
+	assert.Equal(t, "second", workers[1].WorkerID)
+	assert.Equal(t, second.cmd.Process.Pid, workers[1].PID)
+	assert.Equal(t, "fn", workers[1].FunctionID)
+	assert.GreaterOrEqual(t, workers[0].Uptime, workers[1].Uptime)
+
+	first.Stop()
+	workers = r.Workers()
+	require.Len(t, workers, 1)
+	assert.Equal(t, "second", workers[0].WorkerID)
+
+	r.StopAll()
+	assert.Empty(t, r.Workers())
+	select {
+	case <-second.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not exit after StopAll")
+	}
+}
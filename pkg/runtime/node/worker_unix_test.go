@@ -0,0 +1,70 @@
+//go:build !windows
+
+package node
+
+import (
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerStopKillsChildren(t *testing.T) {
+	previous := stopGrace
+	stopGrace = 200 * time.Millisecond
+	t.Cleanup(func() { stopGrace = previous })
+
+	// The child ignores SIGTERM so only the forced kill stops it.
+	worker := runShell(t, `sh -c 'trap "" TERM; echo $$; while :; do sleep 0.05; done' & wait`)
+	lines := worker.LogLines()
+	line := <-lines
+	child, err := strconv.Atoi(line.Text)
+	require.NoError(t, err)
+	parent := worker.cmd.Process.Pid
+	require.NoError(t, syscall.Kill(child, 0))
+
+	worker.Stop()
+	go func() {
+		for range lines {
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		return syscall.Kill(parent, 0) == syscall.ESRCH && syscall.Kill(child, 0) == syscall.ESRCH
+	}, 5*time.Second, 20*time.Millisecond)
+	syscall.Kill(child, syscall.SIGKILL)
+}
+
+func TestWorkerStopSkipsReapedGroup(t *testing.T) {
+	previous := stopGrace
+	stopGrace = 200 * time.Millisecond
+	t.Cleanup(func() { stopGrace = previous })
+	kills := make(chan int, 1)
+	previousKill := killProcessGroup
+	killProcessGroup = func(pid int) error {
+		kills <- pid
+		return nil
+	}
+	t.Cleanup(func() { killProcessGroup = previousKill })
+
+	// Without children the group is empty once the worker is reaped, its id
+	// may then belong to an unrelated process.
+	worker := runShell(t, "exec sleep 10")
+	go func() {
+		for range worker.LogLines() {
+		}
+	}()
+	worker.Stop()
+	select {
+	case <-worker.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not exit")
+	}
+	select {
+	case pid := <-kills:
+		t.Fatalf("killed reaped process group %v", pid)
+	case <-time.After(2 * stopGrace):
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/sst/ion/pkg/project/path"
 )
@@ -19,6 +20,14 @@ type Runtime interface {
 	ShouldRebuild(functionID string, path string) bool
 }
 
+// CachedRuntime is implemented by runtimes that keep the output of earlier
+// builds. Cached reports whether the function's last output in Out is still
+// current, in which case Collection returns it instead of clearing Out and
+// building again.
+type CachedRuntime interface {
+	Cached(input *BuildInput) (*BuildOutput, bool)
+}
+
 type Worker interface {
 	Stop()
 	Logs() io.ReadCloser
@@ -32,10 +41,7 @@ type BuildInput struct {
 	Runtime    string                     `json:"runtime"`
 	Properties json.RawMessage            `json:"properties"`
 	Links      map[string]json.RawMessage `json:"links"`
-	CopyFiles  []struct {
-		From string `json:"from"`
-		To   string `json:"to"`
-	} `json:"copyFiles"`
+	CopyFiles  []CopyFile                 `json:"copyFiles"`
 }
 
 func (input *BuildInput) Out() string {
@@ -47,9 +53,64 @@ func (input *BuildInput) Out() string {
 }
 
 type BuildOutput struct {
-	Out     string   `json:"out"`
-	Handler string   `json:"handler"`
-	Errors  []string `json:"errors"`
+	Out     string `json:"out"`
+	Handler string `json:"handler"`
+	// Entry is the absolute path of the handler's source file.
+	Entry    string   `json:"entry,omitempty"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+	// DetailedErrors and DetailedWarnings carry the same messages as Errors
+	// and Warnings with their source location split out for editor
+	// integrations.
+	DetailedErrors   []BuildError `json:"detailedErrors,omitempty"`
+	DetailedWarnings []BuildError `json:"detailedWarnings,omitempty"`
+	// Size is the total size in bytes of all output files, broken down per
+	// file relative to Out in Sizes.
+	Size  int64            `json:"size"`
+	Sizes map[string]int64 `json:"sizes"`
+	// Hash is a SHA-256 of the output files, excluding sourcemaps.
+	Hash string `json:"hash"`
+	// SourceMap is the absolute path of the handler's sourcemap, empty when
+	// sourcemaps are inline or disabled.
+	SourceMap string `json:"sourceMap,omitempty"`
+	// Archive is the path of a zip of Out when the runtime was asked to
+	// produce one.
+	Archive string `json:"archive,omitempty"`
+	// Assets are the absolute paths of stylesheets emitted alongside the
+	// bundle, such as the companion .css of a handler importing CSS.
+	Assets []string `json:"assets,omitempty"`
+	// Inputs are the absolute paths of every file bundled, for watchers that
+	// want to register exactly what the build depends on.
+	Inputs []string `json:"inputs,omitempty"`
+	// Externals are the packages the bundle imports but leaves external, which
+	// have to be present in node_modules at runtime.
+	Externals []string `json:"externals,omitempty"`
+	// Incremental reports whether esbuild reused the function's existing build
+	// context, Duration is how long the bundler took. Builds served from the
+	// runtime's output cache are incremental with a zero duration.
+	Incremental bool          `json:"incremental"`
+	Duration    time.Duration `json:"duration"`
+	// PostBuildOutput is the combined output of the function's post build
+	// command, if it has one.
+	PostBuildOutput string `json:"postBuildOutput,omitempty"`
+	// Metafile and OutputFiles are only populated by dry-run builds, which
+	// keep their output in memory instead of writing it to Out.
+	Metafile    string       `json:"metafile,omitempty"`
+	OutputFiles []OutputFile `json:"outputFiles,omitempty"`
+}
+
+type BuildError struct {
+	Text   string `json:"text"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// Plugin names the bundler plugin that reported the message, if any.
+	Plugin string `json:"plugin,omitempty"`
+}
+
+type OutputFile struct {
+	Path     string `json:"path"`
+	Contents []byte `json:"contents"`
 }
 
 type RunInput struct {
@@ -60,6 +121,17 @@ type RunInput struct {
 	WorkerID   string
 	Build      *BuildOutput
 	Env        []string
+	// EnvFile is a dotenv file, relative to the project root, loaded into the
+	// worker environment. Runtimes fall back to a .env in the project root.
+	// Values in Env take precedence over the file.
+	EnvFile string
+	// Entrypoint replaces the runtime's own shim that loads the handler, for
+	// custom installs and tests. Relative paths are resolved from the project
+	// root.
+	Entrypoint string
+	// Redact names environment variables, from Env or the env file, whose
+	// values are masked as *** in the worker output and runtime logs.
+	Redact []string
 }
 
 type Collection struct {
@@ -93,6 +165,13 @@ func (c *Collection) Build(ctx context.Context, input *BuildInput) (*BuildOutput
 		return nil, fmt.Errorf("Runtime not found: %v", input.Runtime)
 	}
 	out := input.Out()
+	if cached, ok := runtime.(CachedRuntime); ok {
+		if result, ok := cached.Cached(input); ok {
+			slog.Info("function unchanged, keeping output", "functionID", input.FunctionID)
+			result.Out = out
+			return result, nil
+		}
+	}
 	if err := os.RemoveAll(out); err != nil {
 		return nil, err
 	}
@@ -106,41 +185,12 @@ func (c *Collection) Build(ctx context.Context, input *BuildInput) (*BuildOutput
 	result.Out = out
 
 	if len(input.CopyFiles) > 0 {
-		for _, item := range input.CopyFiles {
-			from, err := filepath.Abs(item.From)
-			if err != nil {
-				return nil, err
-			}
-			var dest string
-			if item.To != "" {
-				dest = filepath.Join(out, item.To)
-			} else {
-				dest = filepath.Join(out, item.From)
-			}
-			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-				return nil, err
-			}
-			if input.Dev {
-				if err := os.Symlink(from, dest); err != nil {
-					return nil, err
-				}
-			}
-			if !input.Dev {
-				sourceFile, err := os.Open(from)
-				if err != nil {
-					return nil, err
-				}
-				defer sourceFile.Close()
-				destFile, err := os.Create(dest)
-				if err != nil {
-					return nil, err
-				}
-				defer destFile.Close()
-				_, err = io.Copy(destFile, sourceFile)
-				if err != nil {
-					return nil, err
-				}
-			}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if err := CopyFiles(cwd, out, input.CopyFiles, input.Dev); err != nil {
+			return nil, err
 		}
 	}
 
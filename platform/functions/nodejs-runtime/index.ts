@@ -8,11 +8,19 @@ const handler = process.argv[2];
 const AWS_LAMBDA_RUNTIME_API = `http://` + process.env.AWS_LAMBDA_RUNTIME_API!;
 const parsed = path.parse(handler);
 
-const file = [".js", ".jsx", ".mjs", ".cjs"]
-  .map((ext) => path.join(parsed.dir, parsed.name + ext))
-  .find((file) => {
-    return fs.existsSync(file);
-  })!;
+// the handler can point to a nested export like index.nested.handler so use
+// the longest prefix that exists as a file
+const segments = parsed.base.split(".");
+let file!: string;
+let exportPath: string[] = [];
+for (let i = segments.length - 1; i > 0 && !file; i--) {
+  file = [".js", ".jsx", ".mjs", ".cjs"]
+    .map((ext) => path.join(parsed.dir, segments.slice(0, i).join(".") + ext))
+    .find((file) => {
+      return fs.existsSync(file);
+    })!;
+  exportPath = segments.slice(i);
+}
 
 let fn: any;
 let timeout: NodeJS.Timeout | undefined;
@@ -45,11 +53,10 @@ process.on("uncaughtException", error);
 try {
   const { href } = url.pathToFileURL(file);
   const mod = await import(href);
-  const handler = parsed.ext.substring(1);
-  fn = mod[handler];
+  fn = exportPath.reduce((value, key) => value?.[key], mod);
   if (!fn) {
     throw new Error(
-      `Function "${handler}" not found in "${handler}". Found ${Object.keys(
+      `Function "${exportPath.join(".")}" not found in "${file}". Found ${Object.keys(
         mod,
       ).join(", ")}`,
     );
@@ -59,6 +66,9 @@ try {
   process.exit(1);
 }
 
+// tells the cli the handler loaded and invocations can be routed here
+process.stdout.write("sst:worker:ready\n");
+
 while (true) {
   if (timeout) clearTimeout(timeout);
   timeout = setTimeout(
@@ -1,54 +1,51 @@
 import { createInterface } from "readline";
 import { stdin as input, stdout as output } from "process";
-import fs from "fs/promises";
-// open file and append to it
 
-// create file and open it for writing
-const file = await fs.open("out", "w");
-
-const plugins = await import(process.argv[2]);
+const options = JSON.parse(process.argv[3] || "null") || {};
+const mod = await import(process.argv[2]);
+const plugins =
+  typeof mod.default === "function" ? await mod.default(options) : mod.default;
 
 const onResolve = [];
 const onLoad = [];
 
-const stubAPI = {
-  onResolve(options, callback) {
-    onResolve.push({ options, callback });
-  },
-  onLoad(options, callback) {
-    onLoad.push({ options, callback });
-  },
-};
-
-for (const plugin of plugins.default) {
-  plugin.setup(stubAPI);
+for (const plugin of plugins) {
+  const name = plugin.name;
+  await plugin.setup({
+    onResolve(options, callback) {
+      onResolve.push({ name, options, callback });
+    },
+    onLoad(options, callback) {
+      onLoad.push({ name, options, callback });
+    },
+  });
 }
 
 const rl = createInterface({ input, output, terminal: false });
 
-rl.on("line", async (line) => {
-  const request = JSON.parse(line);
-  await file.write("request: " + JSON.stringify(request) + "\n");
-  let result;
+// Requests are answered in the order they arrive.
+let queue = Promise.resolve();
+rl.on("line", (line) => {
+  queue = queue.then(() => handle(JSON.parse(line)));
+});
 
-  if (request.type === "resolve") {
-    for (const { options, callback } of onResolve) {
-      if (new RegExp(options.filter).test(request.path)) {
-        result = callback(request);
-        if (result) break;
-      }
-    }
-  }
+async function handle(request) {
+  const callbacks = request.type === "resolve" ? onResolve : onLoad;
+  let result;
 
-  if (request.type === "load") {
-    for (const { options, callback } of onLoad) {
-      if (new RegExp(options.filter).test(request.path)) {
-        result = callback(request);
-        if (result) break;
-      }
+  for (const { name, options, callback } of callbacks) {
+    if (!new RegExp(options.filter).test(request.path)) continue;
+    try {
+      result = await callback(request);
+    } catch (error) {
+      // Report the failure to esbuild instead of leaving the request
+      // unanswered.
+      result = {
+        errors: [{ text: String(error?.message ?? error), pluginName: name }],
+      };
     }
+    if (result) break;
   }
 
-  await file.write("result: " + JSON.stringify(result) + "\n");
   output.write(JSON.stringify(result || {}) + "\n");
-});
+}